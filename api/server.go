@@ -2,18 +2,78 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/danielpaulus/software-bluetooth-keyboard/capture"
 	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
 	log "github.com/sirupsen/logrus"
 )
 
-func StartServer(keyboard hid.Keyboard) {
+// Profile controls the connections of a keyboard
+type Profile interface {
+	// Unplug sends a virtual cable unplug to the connected hosts
+	Unplug() error
+}
+
+// Instance is one virtual keyboard served by the REST API
+type Instance struct {
+	Name     string
+	Keyboard hid.Keyboard
+	// Mouse moves the pointer of the hosts of Keyboard, it may be nil
+	Mouse   hid.Mouse
+	Capture *capture.Tap
+	Profile Profile
+}
+
+// StartServer serves the endpoints of every instance below /<name>/.
+// The first instance is also served at the root, so clients that only
+// know about a single keyboard keep working.
+func StartServer(instances []Instance) {
 	// Create a mux for routing incoming requests
 	m := http.NewServeMux()
 
-	m.HandleFunc("/supportedKeys", func(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, len(instances))
+	for i, instance := range instances {
+		names[i] = instance.Name
+		register(m, "/"+instance.Name, instance)
+		if i == 0 {
+			register(m, "", instance)
+		}
+	}
+
+	m.HandleFunc("/instances", func(w http.ResponseWriter, r *http.Request) {
+		output, err := json.Marshal(names)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
+	})
+
+	m.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/plain; version=0.0.4")
+		writeMetrics(w, instances)
+	})
+
+	// Create a server listening on port 8000
+	s := &http.Server{
+		Addr:    ":8080",
+		Handler: m,
+	}
+	log.Info("Starting REST API")
+	// Continue to process new requests until an error occurs
+	log.Fatal(s.ListenAndServe())
+}
+
+func register(m *http.ServeMux, prefix string, instance Instance) {
+	keyboard, tap := instance.Keyboard, instance.Capture
+
+	m.HandleFunc(prefix+"/supportedKeys", func(w http.ResponseWriter, r *http.Request) {
 		output, err := json.Marshal(hid.SupportedKeys())
 		if err != nil {
 			http.Error(w, err.Error(), 500)
@@ -23,8 +83,60 @@ func StartServer(keyboard hid.Keyboard) {
 		w.Write(output)
 	})
 
+	m.HandleFunc(prefix+"/layouts", func(w http.ResponseWriter, r *http.Request) {
+		output, err := json.Marshal(hid.LayoutNames())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
+	})
+
+	m.HandleFunc(prefix+"/status", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, ok := target(w, r, keyboard)
+		if !ok {
+			return
+		}
+		output, err := json.Marshal(keyboard.Status())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
+	})
+
+	m.HandleFunc(prefix+"/hosts", func(w http.ResponseWriter, r *http.Request) {
+		output, err := json.Marshal(keyboard.Status().Hosts)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
+	})
+
+	m.HandleFunc(prefix+"/leds", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, ok := target(w, r, keyboard)
+		if !ok {
+			return
+		}
+		output, err := json.Marshal(keyboard.Status().LEDs)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
+	})
+
 	// All URLs will be handled by this function
-	m.HandleFunc("/sendKey", func(w http.ResponseWriter, r *http.Request) {
+	m.HandleFunc(prefix+"/sendKey", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, ok := target(w, r, keyboard)
+		if !ok {
+			return
+		}
 		b, err := ioutil.ReadAll(r.Body)
 		defer r.Body.Close()
 		if err != nil {
@@ -43,11 +155,17 @@ func StartServer(keyboard hid.Keyboard) {
 			http.Error(w, "Not ready", 500)
 			return
 		}
-		keyboard.TypeKey(key)
+		if err := keyboard.TypeKey(key); err != nil {
+			sendError(w, err)
+		}
 	})
 
 	// All URLs will be handled by this function
-	m.HandleFunc("/typeText", func(w http.ResponseWriter, r *http.Request) {
+	m.HandleFunc(prefix+"/typeText", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, ok := target(w, r, keyboard)
+		if !ok {
+			return
+		}
 		b, err := ioutil.ReadAll(r.Body)
 		defer r.Body.Close()
 		if err != nil {
@@ -62,19 +180,390 @@ func StartServer(keyboard hid.Keyboard) {
 			return
 		}
 
+		// layout and fallback select the host's keyboard layout and the way
+		// to type characters missing from it for this text only
+		opts := hid.TypeOptions{Layout: r.URL.Query().Get("layout"), Fallback: r.URL.Query().Get("fallback")}
+		if opts.Layout != "" {
+			if _, err := hid.LayoutByName(opts.Layout); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+		if opts.Fallback != "" {
+			if _, err := hid.FallbackByName(opts.Fallback); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+
 		if !keyboard.Status().IsReady {
 			http.Error(w, "Not ready", 500)
 			return
 		}
-		keyboard.TypeText(text)
+		report, err := keyboard.TypeTextWith(text, opts)
+		if err != nil {
+			if _, ok := err.(*hid.UntypableError); ok {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			sendError(w, err)
+			return
+		}
+		output, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
 	})
 
-	// Create a server listening on port 8000
-	s := &http.Server{
-		Addr:    ":8080",
-		Handler: m,
+	// sendChord takes shortcuts like cmd+h, ctrl++ or "ctrl+a, cmd+comma"
+	m.HandleFunc(prefix+"/sendChord", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, ok := target(w, r, keyboard)
+		if !ok {
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if _, err := hid.ParseChords(string(b)); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		if !keyboard.Status().IsReady {
+			http.Error(w, "Not ready", 500)
+			return
+		}
+		if err := keyboard.SendChord(string(b)); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	m.HandleFunc(prefix+"/keyDown", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, key, ok := keyRequest(w, r, keyboard)
+		if !ok {
+			return
+		}
+		if err := keyboard.KeyDown(key); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	m.HandleFunc(prefix+"/keyUp", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, key, ok := keyRequest(w, r, keyboard)
+		if !ok {
+			return
+		}
+		if err := keyboard.KeyUp(key); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	// press holds the key for the duration query parameter, e.g. 500ms
+	m.HandleFunc(prefix+"/press", func(w http.ResponseWriter, r *http.Request) {
+		duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration < 0 || duration > maxPressDuration {
+			http.Error(w, fmt.Sprintf("duration must be between 0 and %s, e.g. 500ms", maxPressDuration), 400)
+			return
+		}
+		keyboard, key, ok := keyRequest(w, r, keyboard)
+		if !ok {
+			return
+		}
+		if err := keyboard.Press(key, duration); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	m.HandleFunc(prefix+"/releaseAll", func(w http.ResponseWriter, r *http.Request) {
+		keyboard, ok := target(w, r, keyboard)
+		if !ok {
+			return
+		}
+		if err := keyboard.ReleaseAll(); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	if instance.Mouse != nil {
+		registerMouse(m, prefix, keyboard, instance.Mouse)
 	}
-	log.Info("Starting REST API")
-	// Continue to process new requests until an error occurs
-	log.Fatal(s.ListenAndServe())
+
+	m.HandleFunc(prefix+"/unplug", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unplug requires POST", 405)
+			return
+		}
+		if err := instance.Profile.Unplug(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	})
+
+	m.HandleFunc(prefix+"/capture", func(w http.ResponseWriter, r *http.Request) {
+		output, err := json.Marshal(tap.Status())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write(output)
+	})
+
+	m.HandleFunc(prefix+"/capture/start", func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		var config capture.Config
+		if err := json.Unmarshal(b, &config); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := tap.Start(config); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	})
+
+	m.HandleFunc(prefix+"/capture/stop", func(w http.ResponseWriter, r *http.Request) {
+		if err := tap.Stop(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	})
+}
+
+// target returns the keyboard of the host selected by the optional target
+// query parameter, a BlueZ device path or address. Without it keys are typed
+// to all hosts.
+func target(w http.ResponseWriter, r *http.Request, keyboard hid.Keyboard) (hid.Keyboard, bool) {
+	keyboard, err := keyboard.Target(r.URL.Query().Get("target"))
+	if err == hid.ErrUnknownHost {
+		http.Error(w, err.Error(), 404)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	return keyboard, true
+}
+
+// sendError answers a request whose reports could not be sent, 503 if no
+// host was ready and 404 if the target disconnected meanwhile
+func sendError(w http.ResponseWriter, err error) {
+	switch err {
+	case hid.ErrNotReady:
+		http.Error(w, err.Error(), 503)
+	case hid.ErrUnknownHost:
+		http.Error(w, err.Error(), 404)
+	default:
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// maxPressDuration limits how long /press holds a key
+const maxPressDuration = time.Minute
+
+// keyRequest reads the key from the request body and checks that the target
+// keyboard can type it
+func keyRequest(w http.ResponseWriter, r *http.Request, keyboard hid.Keyboard) (hid.Keyboard, string, bool) {
+	keyboard, ok := target(w, r, keyboard)
+	if !ok {
+		return nil, "", false
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, "", false
+	}
+	key := string(b)
+	if !hid.IsSupported(key) {
+		http.Error(w, "specified key is not supported, call /supportedKeys for a list of supported keys", 400)
+		return nil, "", false
+	}
+	if !keyboard.Status().IsReady {
+		http.Error(w, "Not ready", 500)
+		return nil, "", false
+	}
+	return keyboard, key, true
+}
+
+// registerMouse serves the mouse endpoints. Movements and buttons are query
+// parameters, e.g. /mouse/drag?button=left&dx=300&dy=-40
+func registerMouse(m *http.ServeMux, prefix string, keyboard hid.Keyboard, mouse hid.Mouse) {
+	m.HandleFunc(prefix+"/mouse/move", func(w http.ResponseWriter, r *http.Request) {
+		mouse, ok := mouseRequest(w, r, keyboard, mouse)
+		if !ok {
+			return
+		}
+		dx, dy, ok := movement(w, r)
+		if !ok {
+			return
+		}
+		if err := mouse.Move(dx, dy); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	buttonHandler := func(f func(mouse hid.Mouse, button hid.MouseButton) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mouse, ok := mouseRequest(w, r, keyboard, mouse)
+			if !ok {
+				return
+			}
+			button, ok := mouseButton(w, r)
+			if !ok {
+				return
+			}
+			if err := f(mouse, button); err != nil {
+				sendError(w, err)
+			}
+		}
+	}
+	m.HandleFunc(prefix+"/mouse/click", buttonHandler(hid.Mouse.Click))
+	m.HandleFunc(prefix+"/mouse/buttonDown", buttonHandler(hid.Mouse.ButtonDown))
+	m.HandleFunc(prefix+"/mouse/buttonUp", buttonHandler(hid.Mouse.ButtonUp))
+
+	m.HandleFunc(prefix+"/mouse/drag", func(w http.ResponseWriter, r *http.Request) {
+		mouse, ok := mouseRequest(w, r, keyboard, mouse)
+		if !ok {
+			return
+		}
+		button, ok := mouseButton(w, r)
+		if !ok {
+			return
+		}
+		dx, dy, ok := movement(w, r)
+		if !ok {
+			return
+		}
+		if err := mouse.Drag(button, dx, dy); err != nil {
+			sendError(w, err)
+		}
+	})
+
+	// scroll turns the wheel by dy, positive values scroll up
+	m.HandleFunc(prefix+"/mouse/scroll", func(w http.ResponseWriter, r *http.Request) {
+		mouse, ok := mouseRequest(w, r, keyboard, mouse)
+		if !ok {
+			return
+		}
+		dy, ok := intParam(w, r, "dy")
+		if !ok {
+			return
+		}
+		if err := mouse.Scroll(dy); err != nil {
+			sendError(w, err)
+		}
+	})
+}
+
+// mouseRequest returns the mouse of the hosts selected by the target query
+// parameter once a host is ready
+func mouseRequest(w http.ResponseWriter, r *http.Request, keyboard hid.Keyboard, mouse hid.Mouse) (hid.Mouse, bool) {
+	keyboard, ok := target(w, r, keyboard)
+	if !ok {
+		return nil, false
+	}
+	mouse, err := mouse.Target(r.URL.Query().Get("target"))
+	if err == hid.ErrUnknownHost {
+		http.Error(w, err.Error(), 404)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if !keyboard.Status().IsReady {
+		http.Error(w, "Not ready", 500)
+		return nil, false
+	}
+	return mouse, true
+}
+
+// maxMouseMove limits the distance of a single move or scroll request
+const maxMouseMove = 1 << 16
+
+// intParam reads an optional integer query parameter, it defaults to 0
+func intParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < -maxMouseMove || v > maxMouseMove {
+		http.Error(w, fmt.Sprintf("%s must be an integer between %d and %d", name, -maxMouseMove, maxMouseMove), 400)
+		return 0, false
+	}
+	return v, true
+}
+
+func movement(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	dx, ok := intParam(w, r, "dx")
+	if !ok {
+		return 0, 0, false
+	}
+	dy, ok := intParam(w, r, "dy")
+	return dx, dy, ok
+}
+
+// mouseButton reads the button query parameter, left if it is missing
+func mouseButton(w http.ResponseWriter, r *http.Request) (hid.MouseButton, bool) {
+	name := r.URL.Query().Get("button")
+	if name == "" {
+		return hid.MouseButtonLeft, true
+	}
+	button, err := hid.ParseMouseButton(name)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return 0, false
+	}
+	return button, true
+}
+
+// writeMetrics writes the status of all instances in the Prometheus text format
+func writeMetrics(w http.ResponseWriter, instances []Instance) {
+	metrics := []struct {
+		name  string
+		typ   string
+		help  string
+		value func(hid.KeyboardStatus) float64
+	}{
+		{"gobt_ready", "gauge", "Whether a host is connected.", func(s hid.KeyboardStatus) float64 { return boolMetric(s.IsReady) }},
+		{"gobt_hosts", "gauge", "Number of connected hosts.", func(s hid.KeyboardStatus) float64 { return float64(len(s.Hosts)) }},
+		{"gobt_send_queue_bytes", "gauge", "Bytes in the send queue of the connection.", func(s hid.KeyboardStatus) float64 { return float64(s.QueueDepth) }},
+		{"gobt_send_queue_max_bytes", "gauge", "Largest send queue of any host seen.", func(s hid.KeyboardStatus) float64 { return float64(s.Pacing.MaxQueueDepth) }},
+		{"gobt_throttled", "gauge", "Whether reports are currently delayed.", func(s hid.KeyboardStatus) float64 { return boolMetric(s.Throttled) }},
+		{"gobt_reports_sent_total", "counter", "Reports sent to all hosts, including disconnected ones.", func(s hid.KeyboardStatus) float64 { return float64(s.Pacing.ReportsSent) }},
+		{"gobt_reports_delayed_total", "counter", "Reports delayed because of the send queue.", func(s hid.KeyboardStatus) float64 { return float64(s.Pacing.ReportsDelayed) }},
+		{"gobt_throttled_seconds_total", "counter", "Time spent delaying reports.", func(s hid.KeyboardStatus) float64 { return s.Pacing.ThrottledTime.Seconds() }},
+	}
+	statuses := make([]hid.KeyboardStatus, len(instances))
+	for i, instance := range instances {
+		statuses[i] = instance.Keyboard.Status()
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.typ)
+		for i, instance := range instances {
+			fmt.Fprintf(w, "%s{instance=%q} %g\n", metric.name, instance.Name, metric.value(statuses[i]))
+		}
+	}
+}
+
+func boolMetric(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
 }
@@ -1,8 +1,12 @@
 package bluetooth
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"unsafe"
@@ -12,319 +16,503 @@ import (
 )
 
 type _Socklen uint32
-type RawSockaddrL2 struct {
-	Family uint16
-	Psm    uint16
-	Bdaddr [6]uint8
-}
-
-// Represents L2CAP socket address
-type SockaddrL2 struct {
-	PSM    uint16
-	Bdaddr [6]uint8
-	raw    RawSockaddrL2
-}
 
-func (sa *SockaddrL2) sockaddr() (unsafe.Pointer, _Socklen, error) {
-	sa.raw.Family = unix.AF_BLUETOOTH
-	sa.raw.Psm = uint16(sa.PSM)
-	sa.raw.Bdaddr = sa.Bdaddr
-
-	return unsafe.Pointer(&sa.raw), _Socklen(unsafe.Sizeof(RawSockaddrL2{})), nil
-}
-
-func (sa *SockaddrL2) String() string {
-	return fmt.Sprintf("[PSM: %d, Bdaddr: %v]", sa.PSM, sa.Bdaddr)
+// RawSockaddrL2 mirrors the kernel's struct sockaddr_l2
+type RawSockaddrL2 struct {
+	Family     uint16
+	Psm        uint16
+	Bdaddr     [6]uint8
+	Cid        uint16
+	BdaddrType uint8
 }
 
 const (
 	PSMCTRL = 0x11
 	PSMINTR = 0x13
 	BUFSIZE = 1024
-
-	FDBITS = 32
 )
 
-var mu sync.Mutex
+// aLongTimeAgo is used as a deadline to interrupt pending I/O
+var aLongTimeAgo = time.Unix(1, 0)
 
-type FdSet struct {
-	Bits [32]int32
+// Bdaddr is a Bluetooth device address stored in kernel byte order,
+// that is least significant byte first.
+type Bdaddr [6]uint8
+
+// BdaddrAny is the wildcard address used to bind on all adapters
+var BdaddrAny = Bdaddr{}
+
+// ParseBdaddr parses an address in the usual "AA:BB:CC:DD:EE:FF" notation
+func ParseBdaddr(s string) (Bdaddr, error) {
+	var b Bdaddr
+	parts := strings.Split(s, ":")
+	if len(parts) != len(b) {
+		return b, fmt.Errorf("invalid bluetooth address: %s", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 16, 8)
+		if err != nil || len(p) != 2 {
+			return b, fmt.Errorf("invalid bluetooth address: %s", s)
+		}
+		b[len(b)-1-i] = uint8(v)
+	}
+	return b, nil
 }
 
-func setFd(fd int, fdset *FdSet) {
-	mask := uint(1) << (uint(fd) % uint(FDBITS))
-	fdset.Bits[fd/FDBITS] |= int32(mask)
+func (b Bdaddr) String() string {
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[5], b[4], b[3], b[2], b[1], b[0])
 }
 
-func isSetFd(fd int, fdset *FdSet) bool {
-	mask := uint(1) << (uint(fd) % uint(FDBITS))
-	return ((fdset.Bits[fd/FDBITS] & int32(mask)) != 0)
+// Addr represents the address of an L2CAP endpoint and implements net.Addr
+type Addr struct {
+	Bdaddr Bdaddr
+	PSM    uint16
 }
 
-type Bluetooth struct {
-	fd     int
-	family int
-	proto  int
-	typ    int
-	saddr  SockaddrL2
+func (a *Addr) Network() string {
+	return "l2cap"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("%s/%#x", a.Bdaddr, a.PSM)
+}
 
-	block bool
-	mu    sync.Mutex
+func (a *Addr) sockaddr() (unsafe.Pointer, _Socklen) {
+	raw := &RawSockaddrL2{
+		Family: unix.AF_BLUETOOTH,
+		Psm:    a.PSM,
+		Bdaddr: a.Bdaddr,
+	}
+	return unsafe.Pointer(raw), _Socklen(unsafe.Sizeof(*raw))
 }
 
-// Sets socket as blocking mode(true) or Non-blocking mode(false)
-func (bt *Bluetooth) SetBlocking(block bool) error {
-	bt.mu.Lock()
-	defer bt.mu.Unlock()
+func addrFromRaw(raw *RawSockaddrL2) *Addr {
+	return &Addr{
+		PSM:    raw.Psm,
+		Bdaddr: raw.Bdaddr,
+	}
+}
 
-	dFlgPtr, _, err := unix.Syscall(unix.SYS_FCNTL, uintptr(bt.fd), unix.F_GETFL, 0)
+// Bluetooth is an L2CAP SEQPACKET connection driven by the runtime poller.
+// It implements net.Conn, so reads and writes may run concurrently
+// and can be interrupted with deadlines.
+type Bluetooth struct {
+	f     *os.File
+	rc    syscall.RawConn
+	laddr *Addr
+	raddr *Addr
+}
 
-	if err != 0 {
-		log.Debug("Error SetBlocking set state", err)
-		return err
+// newFile switches fd to non-blocking mode and hands it to the runtime poller
+func newFile(fd int, name string) (*os.File, syscall.RawConn, error) {
+	unix.CloseOnExec(fd)
+	if err := unix.SetNonblock(fd, true); err != nil {
+		log.Debug("SetNonblock failed", err)
+		unix.Close(fd)
+		return nil, nil, err
 	}
 
-	var delayFlag uint
-	if block {
-		delayFlag = uint(dFlgPtr) & (^(uint)(unix.O_NONBLOCK))
-	} else {
-		delayFlag = uint(dFlgPtr) | ((uint)(unix.O_NONBLOCK))
+	f := os.NewFile(uintptr(fd), name)
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
 	}
+	return f, rc, nil
+}
 
-	_, _, err = unix.Syscall(unix.SYS_FCNTL, uintptr(bt.fd), unix.F_SETFL, uintptr(delayFlag))
-	if err != 0 {
-		log.Debug("Error SetBlocking set state", err)
-		return err
+func rawSockname(fd uintptr, trap uintptr) (*Addr, syscall.Errno) {
+	var rsa RawSockaddrL2
+	var addrlen _Socklen = _Socklen(unsafe.Sizeof(rsa))
+	_, _, errno := unix.RawSyscall(trap, fd, uintptr(unsafe.Pointer(&rsa)), uintptr(unsafe.Pointer(&addrlen)))
+	if errno != 0 {
+		return nil, errno
 	}
+	return addrFromRaw(&rsa), 0
+}
 
-	return nil
+func sockname(rc syscall.RawConn, trap uintptr) (*Addr, error) {
+	var addr *Addr
+	var errno syscall.Errno
+	err := rc.Control(func(fd uintptr) {
+		addr, errno = rawSockname(fd, trap)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, errno
+	}
+	return addr, nil
 }
 
-// Creates L2CAP socket wrapper with given file descriptor
-// This file descriptor is provided by BlueZ DBus interface
-// e.g. org.bluez.Profile1.NewConnection()
-func NewBluetoothSocket(fd int) (*Bluetooth, error) {
-	bt := &Bluetooth{
-		fd:     fd,
-		family: unix.AF_BLUETOOTH,
-		typ:    unix.SOCK_SEQPACKET,
-		proto:  unix.BTPROTO_L2CAP,
-		block:  false,
+func newBluetooth(fd int) (*Bluetooth, error) {
+	f, rc, err := newFile(fd, "l2cap")
+	if err != nil {
+		return nil, err
 	}
+	return bluetoothFromFile(f, rc)
+}
 
-	var rsa RawSockaddrL2
-	var addrlen _Socklen = _Socklen(unsafe.Sizeof(RawSockaddrL2{}))
-	_, _, err := unix.RawSyscall(unix.SYS_GETSOCKNAME, uintptr(fd), uintptr(unsafe.Pointer(&rsa)), uintptr(unsafe.Pointer(&addrlen)))
-	if int(err) != 0 {
+func bluetoothFromFile(f *os.File, rc syscall.RawConn) (*Bluetooth, error) {
+	bt := &Bluetooth{f: f, rc: rc}
+
+	var err error
+	if bt.laddr, err = sockname(rc, unix.SYS_GETSOCKNAME); err != nil {
 		log.Debug("Failure on getsockname", err)
-		unix.Close(fd)
+		f.Close()
 		return nil, err
 	}
+	if bt.raddr, err = sockname(rc, unix.SYS_GETPEERNAME); err != nil {
+		log.Debug("Failure on getpeername", err)
+		f.Close()
+		return nil, err
+	}
+	return bt, nil
+}
 
-	bt.saddr = SockaddrL2{
-		PSM:    rsa.Psm,
-		Bdaddr: rsa.Bdaddr,
+// interruptOnDone expires the deadline of f once ctx is done so that pending
+// I/O on f returns. The returned function must be called after the I/O has
+// finished, it reports whether ctx interrupted the operation.
+func interruptOnDone(ctx context.Context, f *os.File) func() bool {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			f.SetDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+
+	return func() bool {
+		close(stop)
+		<-done
+		if ctx.Err() != nil {
+			f.SetDeadline(time.Time{})
+			return true
+		}
+		return false
 	}
+}
 
-	log.Debug("Resolved sockname", bt.saddr, "New Socket is created")
+// Creates L2CAP socket wrapper with given file descriptor
+// This file descriptor is provided by BlueZ DBus interface
+// e.g. org.bluez.Profile1.NewConnection()
+func NewBluetoothSocket(fd int) (*Bluetooth, error) {
+	bt, err := newBluetooth(fd)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Resolved sockname ", bt.laddr, " peer ", bt.raddr, " New Socket is created")
 
 	return bt, nil
 }
 
-// Creates L2CAP socket and lets it listen on given PSM
-func Listen(psm uint, bklen int, block bool) (*Bluetooth, error) {
-	mu.Lock()
-	defer mu.Unlock()
-	bt := &Bluetooth{
-		family: unix.AF_BLUETOOTH,
-		typ:    unix.SOCK_SEQPACKET, // RFCOMM = SOCK_STREAM, L2CAP = SOCK_SEQPACKET, HCI = SOCK_RAW
-		proto:  unix.BTPROTO_L2CAP,
-		block:  block,
-	}
+// Listener is a listening L2CAP socket and implements net.Listener
+type Listener struct {
+	f     *os.File
+	rc    syscall.RawConn
+	laddr *Addr
+}
 
-	fd, err := unix.Socket(bt.family, bt.typ, bt.proto)
+// Creates L2CAP socket and lets it listen on the PSM given in opts
+func Listen(opts ListenOptions) (*Listener, error) {
+	// RFCOMM = SOCK_STREAM, L2CAP = SOCK_SEQPACKET, HCI = SOCK_RAW
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET, unix.BTPROTO_L2CAP)
 	if err != nil {
 		log.Debug("Socket could not be created", err)
 		return nil, err
 	}
 	log.Debug("Socket is created")
 
-	bt.fd = fd
-	unix.CloseOnExec(bt.fd)
-
-	if err := bt.SetBlocking(block); err != nil {
-		_err := bt.Close()
-		log.Debug("SetBlocking", _err, err)
+	f, rc, err := newFile(fd, "l2cap-listener")
+	if err != nil {
 		return nil, err
 	}
-	log.Debug("Socket is set blocking mode")
+	l := &Listener{f: f, rc: rc, laddr: &Addr{PSM: opts.PSM, Bdaddr: opts.Bdaddr}}
 
-	// because L2CAP socket address struct does not exist in golang's standard libs
-	// must be binded by using very low-level operations
-	addr := SockaddrL2{
-		PSM:    uint16(psm),
-		Bdaddr: [6]uint8{0},
+	// accepted sockets inherit security and MTU from the listening socket
+	if opts.Security != SecuritySDP {
+		if err := setSecurity(rc, opts.Security); err != nil {
+			f.Close()
+			log.Debug("Failure on setting security ", opts.Security, err)
+			return nil, err
+		}
 	}
-	bt.saddr = addr
-	saddr, saddrlen, err := addr.sockaddr()
-
-	if _, _, err := unix.Syscall(unix.SYS_BIND, uintptr(bt.fd), uintptr(saddr), uintptr(saddrlen)); int(err) != 0 {
-		switch int(err) {
-		case 0:
-		default:
-			_err := bt.Close()
-			log.Debug("Failure on Binding Socket", _err, err)
+	if opts.IMTU != 0 {
+		if err := setIMTU(rc, opts.IMTU); err != nil {
+			f.Close()
+			log.Debug("Failure on setting MTU ", opts.IMTU, err)
 			return nil, err
 		}
 	}
-	log.Debug("Socket is binded")
 
-	if err := unix.Listen(bt.fd, bklen); err != nil {
-		_err := bt.Close()
-		log.Debug(_err)
+	// because L2CAP socket address struct does not exist in golang's standard libs
+	// must be binded by using very low-level operations
+	err = bind(rc, l.laddr)
+	if err == nil {
+		var listenErr error
+		err = rc.Control(func(fd uintptr) {
+			listenErr = unix.Listen(int(fd), opts.Backlog)
+		})
+		if err == nil {
+			err = listenErr
+		}
+	}
+	if err != nil {
+		_err := f.Close()
+		log.Debug("Failure on Binding Socket", _err, err)
 		return nil, err
 	}
 	log.Debug("Socket is listening")
 
-	return bt, nil
+	return l, nil
 }
 
-// Accepts on listening socket and return received connection
-func (bt *Bluetooth) Accept() (*Bluetooth, error) {
-	mu.Lock()
-	defer mu.Unlock()
+func bind(rc syscall.RawConn, laddr *Addr) error {
+	saddr, saddrlen := laddr.sockaddr()
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_BIND, fd, uintptr(saddr), uintptr(saddrlen))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
 
+// AcceptL2CAP waits for the next connection on the listening socket.
+// It blocks in the runtime poller and can be interrupted by SetDeadline or Close.
+func (l *Listener) AcceptL2CAP() (*Bluetooth, error) {
 	var nFd int
-	var rAddr *SockaddrL2
-
-	fds := &FdSet{Bits: [32]int32{0}}
-	setFd(bt.fd, fds)
 	for {
 		var raddr RawSockaddrL2
-		var addrlen _Socklen = _Socklen(unsafe.Sizeof(RawSockaddrL2{}))
-		rFd, _, err := unix.Syscall(unix.SYS_ACCEPT, uintptr(bt.fd), uintptr(unsafe.Pointer(&raddr)), uintptr(unsafe.Pointer(&addrlen)))
-		if err != 0 {
-			switch err {
-			case syscall.EAGAIN:
-				time.Sleep(1 * time.Millisecond)
-				continue
-			case syscall.ECONNABORTED:
-				continue
-			}
+		var addrlen _Socklen = _Socklen(unsafe.Sizeof(raddr))
+		var errno syscall.Errno
+		err := l.rc.Read(func(fd uintptr) bool {
+			var rFd uintptr
+			rFd, _, errno = unix.Syscall6(unix.SYS_ACCEPT4, fd, uintptr(unsafe.Pointer(&raddr)), uintptr(unsafe.Pointer(&addrlen)), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0, 0)
+			nFd = int(rFd)
+			return errno != syscall.EAGAIN
+		})
+		if err != nil {
 			log.Debug("Accept: Socket Error", err)
-			unix.Close(int(rFd))
 			return nil, err
 		}
-
-		nFd = int(rFd)
-		rAddr = &SockaddrL2{
-			PSM:    raddr.Psm,
-			Bdaddr: raddr.Bdaddr,
+		if errno == syscall.ECONNABORTED {
+			continue
+		}
+		if errno != 0 {
+			log.Debug("Accept: Socket Error", errno)
+			return nil, errno
 		}
+		log.Debug("Remote Address Info", addrFromRaw(&raddr))
 		break
 	}
 
-	log.Debug("Remote Address Info", rAddr)
+	return newBluetooth(nFd)
+}
 
-	rbt := &Bluetooth{
-		family: bt.family,
-		typ:    bt.typ,
-		proto:  bt.proto,
-		block:  bt.block,
-		fd:     nFd,
-		saddr:  *rAddr,
+// AcceptContext is like AcceptL2CAP but returns early once ctx is done
+func (l *Listener) AcceptContext(ctx context.Context) (*Bluetooth, error) {
+	finish := interruptOnDone(ctx, l.f)
+	bt, err := l.AcceptL2CAP()
+	if finish() {
+		if bt != nil {
+			bt.Close()
+		}
+		return nil, ctx.Err()
 	}
+	return bt, err
+}
 
-	unix.CloseOnExec(nFd)
-	log.Debug("Accept closeonexec")
-
-	if err := rbt.SetBlocking(false); err != nil {
-		_err := bt.Close()
-		_err = rbt.Close()
-		log.Debug(_err, "SetBlocking", err)
+// Accept implements net.Listener
+func (l *Listener) Accept() (net.Conn, error) {
+	bt, err := l.AcceptL2CAP()
+	if err != nil {
 		return nil, err
 	}
-	log.Debug("Accepted Socket could set blocking mode")
+	return bt, nil
+}
 
-	return rbt, nil
+// SetDeadline sets the deadline for pending and future Accept calls
+func (l *Listener) SetDeadline(t time.Time) error {
+	return l.f.SetDeadline(t)
 }
 
-func (bt *Bluetooth) Read(b []byte) (int, error) {
-	bt.mu.Lock()
-	defer bt.mu.Unlock()
+func (l *Listener) Addr() net.Addr {
+	return l.laddr
+}
+
+func (l *Listener) Close() error {
+	return l.f.Close()
+}
+
+// Dialer holds the options for outgoing L2CAP connections
+type Dialer struct {
+	// LocalAddr selects the adapter to connect from, BdaddrAny lets the kernel choose
+	LocalAddr Bdaddr
+	// Security is required before the connection is considered established,
+	// zero keeps the kernel default
+	Security SecurityLevel
+}
+
+// Dial opens an L2CAP connection to psm on the remote device bdaddr
+func Dial(bdaddr Bdaddr, psm uint16) (*Bluetooth, error) {
+	return DialContext(context.Background(), bdaddr, psm)
+}
+
+// DialContext is like Dial but gives up once ctx is done
+func DialContext(ctx context.Context, bdaddr Bdaddr, psm uint16) (*Bluetooth, error) {
+	var d Dialer
+	return d.DialContext(ctx, bdaddr, psm)
+}
 
-	var bp unsafe.Pointer
-	var _zero uintptr
-	if len(b) > 0 {
-		bp = unsafe.Pointer(&b[0])
-	} else {
-		bp = unsafe.Pointer(&_zero)
+// DialContext connects to psm on bdaddr with the options of d
+func (d *Dialer) DialContext(ctx context.Context, bdaddr Bdaddr, psm uint16) (*Bluetooth, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET, unix.BTPROTO_L2CAP)
+	if err != nil {
+		log.Debug("Socket could not be created", err)
+		return nil, err
 	}
-	fds := &FdSet{Bits: [32]int32{0}}
-	setFd(bt.fd, fds)
 
-	var r int
-	for {
-		_r, _, err := unix.Syscall(unix.SYS_READ, uintptr(bt.fd), uintptr(bp), uintptr(len(b)))
+	f, rc, err := newFile(fd, "l2cap")
+	if err != nil {
+		return nil, err
+	}
 
-		if err != 0 {
-			switch err {
-			case syscall.EAGAIN:
-				time.Sleep(1 * time.Millisecond)
-				continue
-			}
-			log.Debug("Bluetooth Read Error", err)
-			return -1, err
+	if d.LocalAddr != BdaddrAny {
+		if err := bind(rc, &Addr{Bdaddr: d.LocalAddr}); err != nil {
+			f.Close()
+			log.Debug("Failure on Binding Socket to ", d.LocalAddr, err)
+			return nil, err
+		}
+	}
+	if d.Security != SecuritySDP {
+		if err := setSecurity(rc, d.Security); err != nil {
+			f.Close()
+			log.Debug("Failure on setting security ", d.Security, err)
+			return nil, err
 		}
+	}
 
-		r = int(_r)
-		break
+	raddr := &Addr{Bdaddr: bdaddr, PSM: psm}
+	finish := interruptOnDone(ctx, f)
+	err = connect(rc, raddr)
+	if finish() {
+		f.Close()
+		return nil, ctx.Err()
 	}
+	if err != nil {
+		f.Close()
+		log.Debug("Connect to ", raddr, " failed ", err)
+		return nil, err
+	}
+	log.Debug("Connected to ", raddr)
 
-	return r, nil
+	return bluetoothFromFile(f, rc)
 }
 
-func (bt *Bluetooth) Write(d []byte) (int, error) {
-	bt.mu.Lock()
-	defer bt.mu.Unlock()
-
-	var dp unsafe.Pointer
-	var _zero uintptr
-	if len(d) > 0 {
-		dp = unsafe.Pointer(&d[0])
-	} else {
-		dp = unsafe.Pointer(&_zero)
+// connect starts a non-blocking connect and waits in the poller until the
+// connection is established or has failed
+func connect(rc syscall.RawConn, raddr *Addr) error {
+	saddr, saddrlen := raddr.sockaddr()
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_CONNECT, fd, uintptr(saddr), uintptr(saddrlen))
+	}); err != nil {
+		return err
+	}
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EINPROGRESS, syscall.EALREADY, syscall.EINTR:
+	default:
+		return errno
 	}
-	fds := &FdSet{Bits: [32]int32{0}}
-	setFd(bt.fd, fds)
-
-	var r int
-	for {
-		_r, _, err := unix.Syscall(unix.SYS_WRITE, uintptr(bt.fd), uintptr(dp), uintptr(len(d)))
 
-		if err != 0 {
-			log.Debug("Bluetooth Write Error", err)
-			return -1, err
+	var connErr error
+	err := rc.Write(func(fd uintptr) bool {
+		v, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			connErr = err
+			return true
 		}
-
-		r = int(_r)
-		break
+		switch syscall.Errno(v) {
+		case syscall.EINPROGRESS, syscall.EALREADY, syscall.EINTR:
+			return false
+		case 0:
+			// a writable socket without error is not necessarily connected yet
+			if _, errno := rawSockname(fd, unix.SYS_GETPEERNAME); errno == syscall.ENOTCONN {
+				return false
+			}
+			return true
+		default:
+			connErr = syscall.Errno(v)
+			return true
+		}
+	})
+	if err != nil {
+		return err
 	}
+	return connErr
+}
 
-	return r, nil
+func (bt *Bluetooth) Read(b []byte) (int, error) {
+	n, err := bt.f.Read(b)
+	if err != nil {
+		log.Debug("Bluetooth Read Error", err)
+	}
+	return n, err
 }
 
-func (bt *Bluetooth) Close() error {
-	bt.mu.Lock()
-	defer bt.mu.Unlock()
-	if bt.fd <= 0 {
-		return unix.EINVAL
+func (bt *Bluetooth) Write(d []byte) (int, error) {
+	n, err := bt.f.Write(d)
+	if err != nil {
+		log.Debug("Bluetooth Write Error", err)
 	}
+	return n, err
+}
 
-	if err := unix.Close(bt.fd); err != nil {
+func (bt *Bluetooth) Close() error {
+	if err := bt.f.Close(); err != nil {
 		log.Debug("Bluetooth Close fd Error", err)
 		return err
 	}
 
 	return nil
 }
+
+func (bt *Bluetooth) LocalAddr() net.Addr {
+	return bt.laddr
+}
+
+func (bt *Bluetooth) RemoteAddr() net.Addr {
+	return bt.raddr
+}
+
+func (bt *Bluetooth) SetDeadline(t time.Time) error {
+	return bt.f.SetDeadline(t)
+}
+
+func (bt *Bluetooth) SetReadDeadline(t time.Time) error {
+	return bt.f.SetReadDeadline(t)
+}
+
+func (bt *Bluetooth) SetWriteDeadline(t time.Time) error {
+	return bt.f.SetWriteDeadline(t)
+}
+
+// SyscallConn gives access to the underlying file descriptor
+func (bt *Bluetooth) SyscallConn() (syscall.RawConn, error) {
+	return bt.rc, nil
+}
+
+var (
+	_ net.Conn     = (*Bluetooth)(nil)
+	_ net.Listener = (*Listener)(nil)
+)
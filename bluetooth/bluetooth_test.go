@@ -0,0 +1,22 @@
+package bluetooth
+
+import "testing"
+
+func TestParseBdaddr(t *testing.T) {
+	addr, err := ParseBdaddr("AA:BB:CC:DD:EE:0F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != (Bdaddr{0x0f, 0xee, 0xdd, 0xcc, 0xbb, 0xaa}) {
+		t.Error("address is not stored in kernel byte order: got ", addr[:])
+	}
+	if addr.String() != "AA:BB:CC:DD:EE:0F" {
+		t.Error("address does not round trip: got ", addr.String())
+	}
+
+	for _, invalid := range []string{"", "AA:BB:CC:DD:EE", "AA:BB:CC:DD:EE:GG", "AAA:BB:CC:DD:EE:F"} {
+		if _, err := ParseBdaddr(invalid); err == nil {
+			t.Error("expected error for ", invalid)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package mgmt is a client for the kernel's Bluetooth management interface.
+// It configures adapters directly over an HCI_CHANNEL_CONTROL socket, so
+// the keyboard does not depend on bluetoothd's main.conf for its class,
+// name, IO capability and visibility.
+package mgmt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	hciDevNone        = 0xffff
+	hciChannelControl = 3
+	maxEventSize      = 0xffff + headerSize
+)
+
+// CommandTimeout limits how long a command waits for its result
+var CommandTimeout = 5 * time.Second
+
+// Client sends commands on the management channel and waits for their results.
+// Commands are serialized, events that do not answer a command are passed to
+// the optional event handler.
+type Client struct {
+	rw io.ReadWriteCloser
+	mu sync.Mutex
+
+	handlerMu sync.Mutex
+	handler   func(*Event)
+}
+
+// Open creates a management socket, this requires CAP_NET_ADMIN
+func Open() (*Client, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrHCI{Dev: hciDevNone, Channel: hciChannelControl}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return NewClient(os.NewFile(uintptr(fd), "mgmt")), nil
+}
+
+// NewClient creates a client on rw, every Read must return one event and
+// every Write sends one command
+func NewClient(rw io.ReadWriteCloser) *Client {
+	return &Client{rw: rw}
+}
+
+func (c *Client) Close() error {
+	return c.rw.Close()
+}
+
+// HandleEvents installs a function that receives all events which are not
+// the result of a command, like New Settings
+func (c *Client) HandleEvents(handler func(*Event)) {
+	c.handlerMu.Lock()
+	defer c.handlerMu.Unlock()
+	c.handler = handler
+}
+
+func (c *Client) dispatch(e *Event) {
+	c.handlerMu.Lock()
+	handler := c.handler
+	c.handlerMu.Unlock()
+	if handler != nil {
+		handler(e)
+		return
+	}
+	log.Debugf("mgmt: unhandled event %#04x on index %d: %x", e.Code, e.Index, e.Params)
+}
+
+// Command sends a command and returns the parameters of its Command Complete event
+func (c *Client) Command(opcode uint16, index uint16, params []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cmd := Command{Opcode: opcode, Index: index, Params: params}
+	b, err := cmd.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.rw.Write(b); err != nil {
+		return nil, err
+	}
+
+	if d, ok := c.rw.(interface{ SetReadDeadline(time.Time) error }); ok {
+		d.SetReadDeadline(time.Now().Add(CommandTimeout))
+	}
+
+	buf := make([]byte, maxEventSize)
+	for {
+		n, err := c.rw.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		e, err := ParseEvent(buf[:n])
+		if err != nil {
+			log.Debug("mgmt: ", err)
+			continue
+		}
+		op, status, ret, ok := e.commandResult()
+		if !ok || op != opcode || e.Index != index {
+			c.dispatch(e)
+			continue
+		}
+		if status != StatusSuccess {
+			return nil, &CommandError{Opcode: opcode, Status: status}
+		}
+		if e.Code == EvCommandStatus {
+			// the command is pending, its result follows in a Command Complete event
+			continue
+		}
+		result := make([]byte, len(ret))
+		copy(result, ret)
+		return result, nil
+	}
+}
+
+// ReadVersion returns the version of the management interface
+func (c *Client) ReadVersion() (Version, error) {
+	ret, err := c.Command(OpReadVersion, IndexNone, nil)
+	if err != nil {
+		return Version{}, err
+	}
+	if len(ret) < 3 {
+		return Version{}, fmt.Errorf("version result too short: %x", ret)
+	}
+	return Version{Version: ret[0], Revision: binary.LittleEndian.Uint16(ret[1:])}, nil
+}
+
+// ReadIndexList returns the indexes of all controllers, e.g. 0 for hci0
+func (c *Client) ReadIndexList() ([]uint16, error) {
+	ret, err := c.Command(OpReadIndexList, IndexNone, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) < 2 {
+		return nil, fmt.Errorf("index list too short: %x", ret)
+	}
+	count := int(binary.LittleEndian.Uint16(ret))
+	if len(ret) < 2+2*count {
+		return nil, fmt.Errorf("index list too short for %d controllers: %x", count, ret)
+	}
+	indexes := make([]uint16, count)
+	for i := range indexes {
+		indexes[i] = binary.LittleEndian.Uint16(ret[2+2*i:])
+	}
+	return indexes, nil
+}
+
+// ReadInfo returns address, settings, class and name of a controller
+func (c *Client) ReadInfo(index uint16) (*ControllerInfo, error) {
+	ret, err := c.Command(OpReadInfo, index, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseControllerInfo(ret)
+}
+
+func (c *Client) setBool(opcode uint16, index uint16, v bool) (Settings, error) {
+	ret, err := c.Command(opcode, index, boolParam(v))
+	if err != nil {
+		return 0, err
+	}
+	return settingsResult(ret)
+}
+
+func (c *Client) SetPowered(index uint16, powered bool) (Settings, error) {
+	return c.setBool(OpSetPowered, index, powered)
+}
+
+func (c *Client) SetConnectable(index uint16, connectable bool) (Settings, error) {
+	return c.setBool(OpSetConnectable, index, connectable)
+}
+
+// SetBondable makes the controller accept pairing requests
+func (c *Client) SetBondable(index uint16, bondable bool) (Settings, error) {
+	return c.setBool(OpSetBondable, index, bondable)
+}
+
+// SetDiscoverable makes the controller visible to inquiries. A timeout in
+// seconds turns discoverable off again, zero keeps it on.
+func (c *Client) SetDiscoverable(index uint16, discoverable bool, timeout uint16) (Settings, error) {
+	params := make([]byte, 3)
+	copy(params, boolParam(discoverable))
+	binary.LittleEndian.PutUint16(params[1:], timeout)
+	ret, err := c.Command(OpSetDiscoverable, index, params)
+	if err != nil {
+		return 0, err
+	}
+	return settingsResult(ret)
+}
+
+// SetDeviceClass sets major and minor class. The service class bits are
+// managed by the kernel based on the registered UUIDs.
+func (c *Client) SetDeviceClass(index uint16, class ClassOfDevice) (ClassOfDevice, error) {
+	ret, err := c.Command(OpSetDeviceClass, index, []byte{class.Major(), class.Minor()})
+	if err != nil {
+		return 0, err
+	}
+	if len(ret) < 3 {
+		return 0, fmt.Errorf("class of device result too short: %x", ret)
+	}
+	return classFromBytes(ret), nil
+}
+
+// SetLocalName sets the name hosts show when they discover the controller
+func (c *Client) SetLocalName(index uint16, name string, shortName string) error {
+	if len(name) >= MaxNameLength || len(shortName) >= MaxShortNameLength {
+		return fmt.Errorf("name %q or short name %q too long", name, shortName)
+	}
+	params := make([]byte, MaxNameLength+MaxShortNameLength)
+	copy(params, name)
+	copy(params[MaxNameLength:], shortName)
+	_, err := c.Command(OpSetLocalName, index, params)
+	return err
+}
+
+// SetIOCapability selects the IO capability used for pairing
+func (c *Client) SetIOCapability(index uint16, capability IOCapability) error {
+	_, err := c.Command(OpSetIOCapability, index, []byte{uint8(capability)})
+	return err
+}
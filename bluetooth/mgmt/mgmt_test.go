@@ -0,0 +1,167 @@
+package mgmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fixtureConn replays events recorded from a controller and checks the
+// commands written to it
+type fixtureConn struct {
+	t        *testing.T
+	commands []string
+	events   []string
+}
+
+func (c *fixtureConn) Write(b []byte) (int, error) {
+	if len(c.commands) == 0 {
+		c.t.Fatalf("unexpected command %x", b)
+	}
+	expected := fixture(c.commands[0])
+	c.commands = c.commands[1:]
+	if !bytes.Equal(b, expected) {
+		c.t.Errorf("expected command %x, got %x", expected, b)
+	}
+	return len(b), nil
+}
+
+func (c *fixtureConn) Read(b []byte) (int, error) {
+	if len(c.events) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, fixture(c.events[0]))
+	c.events = c.events[1:]
+	return n, nil
+}
+
+func (c *fixtureConn) Close() error {
+	return nil
+}
+
+func fixture(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestSetDeviceClass(t *testing.T) {
+	conn := &fixtureConn{t: t,
+		commands: []string{"0e00 0000 0200 05 40"},
+		events: []string{
+			// class of device changed arrives before the command completes
+			"0700 0000 0300 402500",
+			"0100 0000 0600 0e00 00 402500",
+		},
+	}
+	var unsolicited []*Event
+	c := NewClient(conn)
+	c.HandleEvents(func(e *Event) { unsolicited = append(unsolicited, e) })
+
+	class, err := c.SetDeviceClass(0, KeyboardClass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class != KeyboardClass {
+		t.Errorf("expected class %06x, got %06x", KeyboardClass, class)
+	}
+	if len(unsolicited) != 1 || unsolicited[0].Code != EvClassOfDevChanged {
+		t.Errorf("expected the class of device changed event to be dispatched, got %v", unsolicited)
+	}
+}
+
+func TestSetDiscoverable(t *testing.T) {
+	conn := &fixtureConn{t: t,
+		commands: []string{"0600 0100 0300 01 0000"},
+		events: []string{
+			"0600 0100 0400 db0a0000",
+			"0100 0100 0700 0600 00 db0a0000",
+		},
+	}
+	settings, err := NewClient(conn).SetDiscoverable(1, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Settings{SettingPowered, SettingConnectable, SettingDiscoverable, SettingBondable, SettingSSP, SettingBREDR} {
+		if !settings.Has(s) {
+			t.Errorf("expected setting %#x in %#x", s, settings)
+		}
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	conn := &fixtureConn{t: t,
+		commands: []string{"0600 0000 0300 01 0000"},
+		events:   []string{"0200 0000 0300 0600 0f"},
+	}
+	_, err := NewClient(conn).SetDiscoverable(0, true, 0)
+	cmdErr, ok := err.(*CommandError)
+	if !ok || cmdErr.Status != StatusNotPowered || cmdErr.Opcode != OpSetDiscoverable {
+		t.Errorf("expected not powered error, got %v", err)
+	}
+}
+
+func TestSetLocalNameAndIOCapability(t *testing.T) {
+	name := hex.EncodeToString(append([]byte("Virtual Keyboard"), make([]byte, MaxNameLength-16)...))
+	short := hex.EncodeToString(append([]byte("VKbd"), make([]byte, MaxShortNameLength-4)...))
+	conn := &fixtureConn{t: t,
+		commands: []string{
+			"0f00 0000 0401" + name + short,
+			"1800 0000 0100 03",
+		},
+		events: []string{
+			"0100 0000 0701 0f00 00" + name + short,
+			"0100 0000 0300 1800 00",
+		},
+	}
+	c := NewClient(conn)
+	if err := c.SetLocalName(0, "Virtual Keyboard", "VKbd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetIOCapability(0, NoInputNoOutput); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadInfo(t *testing.T) {
+	name := hex.EncodeToString(append([]byte("gobt"), make([]byte, MaxNameLength-4)...))
+	short := hex.EncodeToString(make([]byte, MaxShortNameLength))
+	conn := &fixtureConn{t: t,
+		commands: []string{"0400 0000 0000"},
+		events: []string{
+			"0100 0000 1b01 0400 00" +
+				"ffeeddccbbaa 08 0f00 ffff0100 d10a0000 0c010c" + name + short,
+		},
+	}
+	info, err := NewClient(conn).ReadInfo(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Address != [6]uint8{0xff, 0xee, 0xdd, 0xcc, 0xbb, 0xaa} || info.Version != 8 || info.Manufacturer != 15 {
+		t.Errorf("unexpected controller info %+v", info)
+	}
+	if info.Class != 0x0c010c || info.Name != "gobt" || info.ShortName != "" {
+		t.Errorf("unexpected controller info %+v", info)
+	}
+	if !info.CurrentSettings.Has(SettingPowered) || info.CurrentSettings.Has(SettingDiscoverable) {
+		t.Errorf("unexpected settings %#x", info.CurrentSettings)
+	}
+}
+
+func TestReadIndexList(t *testing.T) {
+	conn := &fixtureConn{t: t,
+		commands: []string{"0300 ffff 0000"},
+		events:   []string{"0100 ffff 0900 0300 00 0200 0000 0100"},
+	}
+	indexes, err := NewClient(conn).ReadIndexList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 2 || indexes[0] != 0 || indexes[1] != 1 {
+		t.Errorf("expected indexes [0 1], got %v", indexes)
+	}
+}
@@ -0,0 +1,293 @@
+package mgmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Management protocol constants, see
+// https://git.kernel.org/pub/scm/bluetooth/bluez.git/tree/doc/mgmt-api.txt
+const (
+	// IndexNone addresses the management interface instead of a controller
+	IndexNone = 0xffff
+
+	headerSize = 6
+)
+
+const (
+	OpReadVersion     = 0x0001
+	OpReadIndexList   = 0x0003
+	OpReadInfo        = 0x0004
+	OpSetPowered      = 0x0005
+	OpSetDiscoverable = 0x0006
+	OpSetConnectable  = 0x0007
+	OpSetBondable     = 0x0009
+	OpSetDeviceClass  = 0x000e
+	OpSetLocalName    = 0x000f
+	OpSetIOCapability = 0x0018
+)
+
+const (
+	EvCommandComplete   = 0x0001
+	EvCommandStatus     = 0x0002
+	EvControllerError   = 0x0003
+	EvIndexAdded        = 0x0004
+	EvIndexRemoved      = 0x0005
+	EvNewSettings       = 0x0006
+	EvClassOfDevChanged = 0x0007
+	EvLocalNameChanged  = 0x0008
+)
+
+// lengths of the name fields in Set Local Name and Read Controller Information
+const (
+	MaxNameLength      = 249
+	MaxShortNameLength = 11
+)
+
+// Status is the status code of a command
+type Status uint8
+
+const (
+	StatusSuccess Status = iota
+	StatusUnknownCommand
+	StatusNotConnected
+	StatusFailed
+	StatusConnectFailed
+	StatusAuthenticationFailed
+	StatusNotPaired
+	StatusNoResources
+	StatusTimeout
+	StatusAlreadyConnected
+	StatusBusy
+	StatusRejected
+	StatusNotSupported
+	StatusInvalidParameters
+	StatusDisconnected
+	StatusNotPowered
+	StatusCancelled
+	StatusInvalidIndex
+	StatusRFKilled
+	StatusAlreadyPaired
+	StatusPermissionDenied
+)
+
+var statusNames = []string{
+	"Success", "Unknown Command", "Not Connected", "Failed", "Connect Failed",
+	"Authentication Failed", "Not Paired", "No Resources", "Timeout",
+	"Already Connected", "Busy", "Rejected", "Not Supported", "Invalid Parameters",
+	"Disconnected", "Not Powered", "Cancelled", "Invalid Index", "RFKilled",
+	"Already Paired", "Permission Denied",
+}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("Unknown Status %#02x", uint8(s))
+}
+
+// CommandError is returned when the kernel rejects a command
+type CommandError struct {
+	Opcode uint16
+	Status Status
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("mgmt command %#04x failed: %s", e.Opcode, e.Status)
+}
+
+// Settings is the bit mask of controller settings
+type Settings uint32
+
+const (
+	SettingPowered Settings = 1 << iota
+	SettingConnectable
+	SettingFastConnectable
+	SettingDiscoverable
+	SettingBondable
+	SettingLinkSecurity
+	SettingSSP
+	SettingBREDR
+	SettingHighSpeed
+	SettingLE
+	SettingAdvertising
+	SettingSecureConnections
+	SettingDebugKeys
+	SettingPrivacy
+	SettingConfiguration
+	SettingStaticAddress
+)
+
+func (s Settings) Has(setting Settings) bool {
+	return s&setting == setting
+}
+
+// IOCapability is used during pairing to select the association model
+type IOCapability uint8
+
+const (
+	DisplayOnly IOCapability = iota
+	DisplayYesNo
+	KeyboardOnly
+	NoInputNoOutput
+	KeyboardDisplay
+)
+
+// ClassOfDevice is the 24 bit class of device
+type ClassOfDevice uint32
+
+// KeyboardClass is a peripheral of minor class keyboard with the limited
+// discoverable service bit set
+const KeyboardClass ClassOfDevice = 0x002540
+
+// Major returns the major device class, e.g. 0x05 for peripherals
+func (c ClassOfDevice) Major() uint8 {
+	return uint8(c>>8) & 0x1f
+}
+
+// Minor returns the minor device class byte as used by Set Device Class
+func (c ClassOfDevice) Minor() uint8 {
+	return uint8(c) & 0xfc
+}
+
+func classFromBytes(b []byte) ClassOfDevice {
+	return ClassOfDevice(b[0]) | ClassOfDevice(b[1])<<8 | ClassOfDevice(b[2])<<16
+}
+
+// Command is a request sent to the kernel
+type Command struct {
+	Opcode uint16
+	Index  uint16
+	Params []byte
+}
+
+// MarshalBinary encodes the command with its header
+func (c *Command) MarshalBinary() ([]byte, error) {
+	if len(c.Params) > 0xffff {
+		return nil, fmt.Errorf("mgmt command parameters too long: %d", len(c.Params))
+	}
+	b := make([]byte, headerSize+len(c.Params))
+	binary.LittleEndian.PutUint16(b[0:], c.Opcode)
+	binary.LittleEndian.PutUint16(b[2:], c.Index)
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(c.Params)))
+	copy(b[headerSize:], c.Params)
+	return b, nil
+}
+
+// Event is a message received from the kernel
+type Event struct {
+	Code   uint16
+	Index  uint16
+	Params []byte
+}
+
+// ParseEvent decodes one event as read from the control socket
+func ParseEvent(b []byte) (*Event, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("mgmt event too short: %x", b)
+	}
+	l := int(binary.LittleEndian.Uint16(b[4:]))
+	if len(b) != headerSize+l {
+		return nil, fmt.Errorf("mgmt event length %d does not match header %d: %x", len(b)-headerSize, l, b)
+	}
+	return &Event{
+		Code:   binary.LittleEndian.Uint16(b[0:]),
+		Index:  binary.LittleEndian.Uint16(b[2:]),
+		Params: b[headerSize:],
+	}, nil
+}
+
+// commandResult decodes Command Complete and Command Status events. It
+// returns the opcode the event answers, its status and the return parameters.
+func (e *Event) commandResult() (opcode uint16, status Status, params []byte, ok bool) {
+	switch e.Code {
+	case EvCommandComplete, EvCommandStatus:
+		if len(e.Params) < 3 {
+			return 0, 0, nil, false
+		}
+		return binary.LittleEndian.Uint16(e.Params), Status(e.Params[2]), e.Params[3:], true
+	}
+	return 0, 0, nil, false
+}
+
+// Settings decodes a New Settings event
+func (e *Event) Settings() (Settings, error) {
+	if e.Code != EvNewSettings || len(e.Params) < 4 {
+		return 0, fmt.Errorf("not a new settings event: %#04x %x", e.Code, e.Params)
+	}
+	return Settings(binary.LittleEndian.Uint32(e.Params)), nil
+}
+
+// Version is returned by Read Management Version Information
+type Version struct {
+	Version  uint8
+	Revision uint16
+}
+
+// ControllerInfo is returned by Read Controller Information
+type ControllerInfo struct {
+	// Address in kernel byte order, least significant byte first
+	Address           [6]uint8
+	Version           uint8
+	Manufacturer      uint16
+	SupportedSettings Settings
+	CurrentSettings   Settings
+	Class             ClassOfDevice
+	Name              string
+	ShortName         string
+}
+
+func parseControllerInfo(b []byte) (*ControllerInfo, error) {
+	if len(b) < 20+MaxNameLength+MaxShortNameLength {
+		return nil, fmt.Errorf("controller information too short: %d bytes", len(b))
+	}
+	info := &ControllerInfo{
+		Version:           b[6],
+		Manufacturer:      binary.LittleEndian.Uint16(b[7:]),
+		SupportedSettings: Settings(binary.LittleEndian.Uint32(b[9:])),
+		CurrentSettings:   Settings(binary.LittleEndian.Uint32(b[13:])),
+		Class:             classFromBytes(b[17:20]),
+		Name:              cString(b[20 : 20+MaxNameLength]),
+		ShortName:         cString(b[20+MaxNameLength : 20+MaxNameLength+MaxShortNameLength]),
+	}
+	copy(info.Address[:], b[0:6])
+	return info, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func boolParam(v bool) []byte {
+	if v {
+		return []byte{0x01}
+	}
+	return []byte{0x00}
+}
+
+func settingsResult(params []byte) (Settings, error) {
+	if len(params) < 4 {
+		return 0, fmt.Errorf("settings result too short: %x", params)
+	}
+	return Settings(binary.LittleEndian.Uint32(params)), nil
+}
+
+var ioCapabilityNames = map[string]IOCapability{
+	"DisplayOnly":     DisplayOnly,
+	"DisplayYesNo":    DisplayYesNo,
+	"KeyboardOnly":    KeyboardOnly,
+	"NoInputNoOutput": NoInputNoOutput,
+	"KeyboardDisplay": KeyboardDisplay,
+}
+
+// ParseIOCapability accepts the names used by BlueZ, e.g. NoInputNoOutput
+func ParseIOCapability(s string) (IOCapability, error) {
+	if c, ok := ioCapabilityNames[s]; ok {
+		return c, nil
+	}
+	return 0, fmt.Errorf("unknown IO capability: %s", s)
+}
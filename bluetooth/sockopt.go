@@ -0,0 +1,200 @@
+package bluetooth
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// socket option levels and names from the kernel's bluetooth.h and l2cap.h,
+// golang.org/x/sys/unix does not define them
+const (
+	SOL_BLUETOOTH = 274
+	SOL_L2CAP     = 6
+
+	BT_SECURITY   = 4
+	BT_POWER      = 9
+	L2CAP_OPTIONS = 0x01
+)
+
+// SecurityLevel is the BT_SECURITY level of a socket
+type SecurityLevel uint8
+
+const (
+	// SecuritySDP is only used for SDP connections
+	SecuritySDP SecurityLevel = iota
+	// SecurityLow requires no authentication and no encryption
+	SecurityLow
+	// SecurityMedium requires an authenticated and encrypted link, MITM protection is not required
+	SecurityMedium
+	// SecurityHigh requires an authenticated and encrypted link with MITM protection
+	SecurityHigh
+	// SecurityFIPS requires Secure Connections with a FIPS approved algorithm
+	SecurityFIPS
+)
+
+func (l SecurityLevel) String() string {
+	switch l {
+	case SecuritySDP:
+		return "sdp"
+	case SecurityLow:
+		return "low"
+	case SecurityMedium:
+		return "medium"
+	case SecurityHigh:
+		return "high"
+	case SecurityFIPS:
+		return "fips"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(l))
+}
+
+// Security mirrors the kernel's struct bt_security
+type Security struct {
+	Level   SecurityLevel
+	KeySize uint8
+}
+
+// L2CAPOptions mirrors the kernel's struct l2cap_options
+type L2CAPOptions struct {
+	OMTU      uint16
+	IMTU      uint16
+	FlushTo   uint16
+	Mode      uint8
+	FCS       uint8
+	MaxTx     uint8
+	TxWinSize uint16
+}
+
+// ListenOptions configures the socket created by Listen
+type ListenOptions struct {
+	PSM     uint16
+	Backlog int
+	// Bdaddr is the address of the adapter to listen on, BdaddrAny listens on all adapters
+	Bdaddr Bdaddr
+	// Security is required on every accepted connection, zero keeps the kernel default
+	Security SecurityLevel
+	// IMTU is the incoming MTU announced to the remote, zero keeps the kernel default
+	IMTU uint16
+}
+
+func getsockopt(rc syscall.RawConn, level, opt int, val unsafe.Pointer, vallen _Socklen) error {
+	var errno syscall.Errno
+	err := rc.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall6(unix.SYS_GETSOCKOPT, fd, uintptr(level), uintptr(opt), uintptr(val), uintptr(unsafe.Pointer(&vallen)), 0)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setsockopt(rc syscall.RawConn, level, opt int, val unsafe.Pointer, vallen _Socklen) error {
+	var errno syscall.Errno
+	err := rc.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall6(unix.SYS_SETSOCKOPT, fd, uintptr(level), uintptr(opt), uintptr(val), uintptr(vallen), 0)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getSecurity(rc syscall.RawConn) (Security, error) {
+	var sec Security
+	err := getsockopt(rc, SOL_BLUETOOTH, BT_SECURITY, unsafe.Pointer(&sec), _Socklen(unsafe.Sizeof(sec)))
+	return sec, err
+}
+
+func setSecurity(rc syscall.RawConn, level SecurityLevel) error {
+	sec := Security{Level: level}
+	return setsockopt(rc, SOL_BLUETOOTH, BT_SECURITY, unsafe.Pointer(&sec), _Socklen(unsafe.Sizeof(sec)))
+}
+
+func getL2CAPOptions(rc syscall.RawConn) (L2CAPOptions, error) {
+	var opts L2CAPOptions
+	err := getsockopt(rc, SOL_L2CAP, L2CAP_OPTIONS, unsafe.Pointer(&opts), _Socklen(unsafe.Sizeof(opts)))
+	return opts, err
+}
+
+func setL2CAPOptions(rc syscall.RawConn, opts L2CAPOptions) error {
+	return setsockopt(rc, SOL_L2CAP, L2CAP_OPTIONS, unsafe.Pointer(&opts), _Socklen(unsafe.Sizeof(opts)))
+}
+
+// setIMTU changes the incoming MTU, the kernel only allows this before the
+// socket is connected
+func setIMTU(rc syscall.RawConn, imtu uint16) error {
+	opts, err := getL2CAPOptions(rc)
+	if err != nil {
+		return err
+	}
+	opts.IMTU = imtu
+	return setL2CAPOptions(rc, opts)
+}
+
+// Security returns the negotiated security of the connection
+func (bt *Bluetooth) Security() (Security, error) {
+	return getSecurity(bt.rc)
+}
+
+// SetSecurity raises the required security level. On a connected socket the
+// kernel starts authentication and encryption if the link does not meet it yet.
+func (bt *Bluetooth) SetSecurity(level SecurityLevel) error {
+	return setSecurity(bt.rc, level)
+}
+
+// L2CAPOptions returns the channel options including the negotiated MTUs
+func (bt *Bluetooth) L2CAPOptions() (L2CAPOptions, error) {
+	return getL2CAPOptions(bt.rc)
+}
+
+// MTU returns the incoming and outgoing MTU of the channel
+func (bt *Bluetooth) MTU() (imtu uint16, omtu uint16, err error) {
+	opts, err := getL2CAPOptions(bt.rc)
+	return opts.IMTU, opts.OMTU, err
+}
+
+// SetPower sets BT_POWER. With forceActive the kernel takes the link out of
+// sniff mode before sending, which keeps the latency of reports low.
+func (bt *Bluetooth) SetPower(forceActive bool) error {
+	var power uint8
+	if forceActive {
+		power = 1
+	}
+	return setsockopt(bt.rc, SOL_BLUETOOTH, BT_POWER, unsafe.Pointer(&power), _Socklen(unsafe.Sizeof(power)))
+}
+
+// OutQueue returns the number of bytes the socket holds in its send queue,
+// that is reports not yet handed to the controller or not yet acknowledged by
+// it. For Bluetooth sockets SIOCOUTQ reports the free space of the send buffer
+// instead of the used space, so the depth is derived from SO_SNDBUF. Both count
+// the memory of queued buffers, which is larger than their payload.
+func (bt *Bluetooth) OutQueue() (int, error) {
+	var free, sndbuf int
+	var ioctlErr, sockoptErr error
+	err := bt.rc.Control(func(fd uintptr) {
+		free, ioctlErr = unix.IoctlGetInt(int(fd), unix.SIOCOUTQ)
+		sndbuf, sockoptErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if ioctlErr != nil {
+		return 0, ioctlErr
+	}
+	if sockoptErr != nil {
+		return 0, sockoptErr
+	}
+	if free >= sndbuf {
+		return 0, nil
+	}
+	return sndbuf - free, nil
+}
@@ -0,0 +1,103 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// btsnoop file format as understood by Wireshark, see
+// https://fte.com/webhelpII/HSU/Content/Technical_Information/BT_Snoop_File_Format.htm
+const (
+	btsnoopVersion = 1
+	// datalinkH4 means every record starts with the H4 packet indicator
+	datalinkH4 = 1002
+
+	// microseconds between 0 AD and the unix epoch
+	btsnoopEpochDelta = 0x00dcddb30f2f8000
+
+	flagReceived = 0x01
+	flagEvent    = 0x02
+
+	h4ACL   = 0x02
+	h4Event = 0x04
+
+	// fixed L2CAP channel used for the synthesized connection setup
+	cidSignaling = 0x0001
+
+	l2capConnReq = 0x02
+	l2capConnRsp = 0x03
+
+	eventConnComplete       = 0x03
+	eventDisconnComplete    = 0x05
+	reasonRemoteUserEndConn = 0x13
+)
+
+var btsnoopMagic = []byte{'b', 't', 's', 'n', 'o', 'o', 'p', 0}
+
+func writeHeader(w io.Writer) (int, error) {
+	hdr := make([]byte, 16)
+	copy(hdr, btsnoopMagic)
+	binary.BigEndian.PutUint32(hdr[8:], btsnoopVersion)
+	binary.BigEndian.PutUint32(hdr[12:], datalinkH4)
+	return w.Write(hdr)
+}
+
+// record encodes one btsnoop packet record with its H4 payload
+func record(ts time.Time, flags uint32, packet []byte) []byte {
+	r := make([]byte, 24+len(packet))
+	binary.BigEndian.PutUint32(r[0:], uint32(len(packet)))
+	binary.BigEndian.PutUint32(r[4:], uint32(len(packet)))
+	binary.BigEndian.PutUint32(r[8:], flags)
+	binary.BigEndian.PutUint32(r[12:], 0)
+	binary.BigEndian.PutUint64(r[16:], uint64(ts.UnixNano()/1000+btsnoopEpochDelta))
+	copy(r[24:], packet)
+	return r
+}
+
+// aclPacket wraps an L2CAP payload for cid into an H4 ACL data packet
+func aclPacket(handle uint16, cid uint16, payload []byte) []byte {
+	p := make([]byte, 9+len(payload))
+	p[0] = h4ACL
+	// packet boundary flag 0b10: first automatically flushable packet
+	binary.LittleEndian.PutUint16(p[1:], handle&0x0fff|0x2000)
+	binary.LittleEndian.PutUint16(p[3:], uint16(4+len(payload)))
+	binary.LittleEndian.PutUint16(p[5:], uint16(len(payload)))
+	binary.LittleEndian.PutUint16(p[7:], cid)
+	copy(p[9:], payload)
+	return p
+}
+
+func connectionRequest(id uint8, psm uint16, scid uint16) []byte {
+	c := make([]byte, 8)
+	c[0] = l2capConnReq
+	c[1] = id
+	binary.LittleEndian.PutUint16(c[2:], 4)
+	binary.LittleEndian.PutUint16(c[4:], psm)
+	binary.LittleEndian.PutUint16(c[6:], scid)
+	return c
+}
+
+func connectionResponse(id uint8, dcid uint16, scid uint16) []byte {
+	c := make([]byte, 12)
+	c[0] = l2capConnRsp
+	c[1] = id
+	binary.LittleEndian.PutUint16(c[2:], 8)
+	binary.LittleEndian.PutUint16(c[4:], dcid)
+	binary.LittleEndian.PutUint16(c[6:], scid)
+	// result and status are both zero, connection successful
+	return c
+}
+
+func connectionComplete(handle uint16, bdaddr [6]uint8) []byte {
+	e := []byte{h4Event, eventConnComplete, 11, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00}
+	binary.LittleEndian.PutUint16(e[4:], handle)
+	copy(e[6:12], bdaddr[:])
+	return e
+}
+
+func disconnectionComplete(handle uint16) []byte {
+	e := []byte{h4Event, eventDisconnComplete, 4, 0x00, 0, 0, reasonRemoteUserEndConn}
+	binary.LittleEndian.PutUint16(e[4:], handle)
+	return e
+}
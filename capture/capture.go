@@ -0,0 +1,260 @@
+// Package capture records the HIDP traffic of keyboard sessions into btsnoop
+// files that can be opened with Wireshark.
+//
+// Only the L2CAP payloads are visible to us, so the capture synthesizes the
+// HCI connection events and L2CAP connection setup around them. That is enough
+// for Wireshark to decode the control and interrupt channels as HIDP.
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config selects the capture file and its rotation
+type Config struct {
+	Path string `json:"path"`
+	// MaxSize is the size in bytes at which the file is rotated, zero disables rotation
+	MaxSize int64 `json:"maxSize"`
+	// MaxFiles is the number of files kept including the current one, so the
+	// capture never takes more than MaxSize*MaxFiles bytes on disk
+	MaxFiles int `json:"maxFiles"`
+}
+
+// Status describes a running capture
+type Status struct {
+	Enabled bool   `json:"enabled"`
+	Config  Config `json:"config"`
+	Size    int64  `json:"size"`
+}
+
+type channelInfo struct {
+	psm       uint16
+	localCID  uint16
+	remoteCID uint16
+	signalID  uint8
+}
+
+var (
+	ctrlChannel = channelInfo{psm: bluetooth.PSMCTRL, localCID: 0x0040, remoteCID: 0x0050, signalID: 1}
+	intrChannel = channelInfo{psm: bluetooth.PSMINTR, localCID: 0x0041, remoteCID: 0x0051, signalID: 2}
+)
+
+type conn struct {
+	handle uint16
+	bdaddr bluetooth.Bdaddr
+	open   int
+}
+
+// Tap records the traffic of all sessions wrapped by it while a capture is
+// running. Captures can be started and stopped at any time, sessions that are
+// already connected show up in a new file with their connection setup.
+type Tap struct {
+	mu         sync.Mutex
+	cfg        Config
+	file       *os.File
+	size       int64
+	nextHandle uint16
+	conns      map[uint16]*conn
+	// rotating suppresses rotation while a new file gets its connection setup
+	rotating bool
+}
+
+func NewTap() *Tap {
+	return &Tap{nextHandle: 1, conns: make(map[uint16]*conn)}
+}
+
+// Start begins writing a new capture file, a running capture is stopped first
+func (t *Tap) Start(cfg Config) error {
+	if cfg.Path == "" {
+		return errors.New("capture path must not be empty")
+	}
+	if cfg.MaxSize < 0 || cfg.MaxFiles < 0 {
+		return errors.New("capture limits must not be negative")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closeFile()
+	t.cfg = cfg
+	if err := t.openFile(); err != nil {
+		return err
+	}
+	log.Infof("Capturing HIDP traffic to %s", cfg.Path)
+	return nil
+}
+
+// Stop ends the running capture
+func (t *Tap) Stop() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return errors.New("no capture running")
+	}
+	return t.closeFile()
+}
+
+func (t *Tap) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Status{Enabled: t.file != nil, Config: t.cfg, Size: t.size}
+}
+
+// Wrap returns transports that record all messages passing the control and
+// interrupt channel of one session
+func (t *Tap) Wrap(ctrl, intr transport.Transport) (transport.Transport, transport.Transport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := &conn{handle: t.nextHandle, open: 2}
+	t.nextHandle = t.nextHandle%0x0eff + 1
+	if ra, ok := ctrl.(interface{ RemoteAddr() net.Addr }); ok {
+		if addr, ok := ra.RemoteAddr().(*bluetooth.Addr); ok {
+			c.bdaddr = addr.Bdaddr
+		}
+	}
+	t.conns[c.handle] = c
+	t.writeSetup(c)
+
+	return &channel{Transport: ctrl, tap: t, conn: c, info: ctrlChannel},
+		&channel{Transport: intr, tap: t, conn: c, info: intrChannel}
+}
+
+func (t *Tap) openFile() error {
+	f, err := os.Create(t.cfg.Path)
+	if err != nil {
+		return err
+	}
+	n, err := writeHeader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.file = f
+	t.size = int64(n)
+	for _, c := range t.conns {
+		t.writeSetup(c)
+	}
+	return nil
+}
+
+func (t *Tap) closeFile() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	t.size = 0
+	return err
+}
+
+func (t *Tap) rotate() error {
+	t.closeFile()
+	if t.cfg.MaxFiles > 1 {
+		os.Remove(fmt.Sprintf("%s.%d", t.cfg.Path, t.cfg.MaxFiles-1))
+		for i := t.cfg.MaxFiles - 2; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", t.cfg.Path, i), fmt.Sprintf("%s.%d", t.cfg.Path, i+1))
+		}
+		if err := os.Rename(t.cfg.Path, t.cfg.Path+".1"); err != nil {
+			return err
+		}
+	}
+	return t.openFile()
+}
+
+// write appends a record, t.mu must be held
+func (t *Tap) write(flags uint32, packet []byte) {
+	if t.file == nil {
+		return
+	}
+	r := record(time.Now(), flags, packet)
+	if t.cfg.MaxSize > 0 && t.size+int64(len(r)) > t.cfg.MaxSize && !t.rotating {
+		t.rotating = true
+		err := t.rotate()
+		t.rotating = false
+		if err != nil {
+			log.Warn("Rotating capture file failed, capture stopped: ", err)
+			t.closeFile()
+			return
+		}
+		if t.file == nil {
+			return
+		}
+	}
+	n, err := t.file.Write(r)
+	t.size += int64(n)
+	if err != nil {
+		log.Warn("Writing capture file failed, capture stopped: ", err)
+		t.closeFile()
+	}
+}
+
+// writeSetup records the connection and L2CAP channel setup of c, t.mu must be held
+func (t *Tap) writeSetup(c *conn) {
+	t.write(flagReceived|flagEvent, connectionComplete(c.handle, c.bdaddr))
+	for _, info := range []channelInfo{ctrlChannel, intrChannel} {
+		t.write(flagReceived, aclPacket(c.handle, cidSignaling, connectionRequest(info.signalID, info.psm, info.remoteCID)))
+		t.write(0, aclPacket(c.handle, cidSignaling, connectionResponse(info.signalID, info.localCID, info.remoteCID)))
+	}
+}
+
+func (t *Tap) data(c *conn, cid uint16, flags uint32, payload []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.write(flags, aclPacket(c.handle, cid, payload))
+}
+
+func (t *Tap) closed(c *conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.open--
+	if c.open > 0 {
+		return
+	}
+	t.write(flagReceived|flagEvent, disconnectionComplete(c.handle))
+	delete(t.conns, c.handle)
+}
+
+type channel struct {
+	transport.Transport
+	tap  *Tap
+	conn *conn
+	info channelInfo
+	once sync.Once
+}
+
+func (ch *channel) Read(b []byte) (int, error) {
+	n, err := ch.Transport.Read(b)
+	if n > 0 {
+		ch.tap.data(ch.conn, ch.info.localCID, flagReceived, b[:n])
+	}
+	return n, err
+}
+
+func (ch *channel) Write(b []byte) (int, error) {
+	n, err := ch.Transport.Write(b)
+	if err == nil {
+		ch.tap.data(ch.conn, ch.info.remoteCID, 0, b[:n])
+	}
+	return n, err
+}
+
+func (ch *channel) Close() error {
+	ch.once.Do(func() {
+		ch.tap.closed(ch.conn)
+	})
+	return ch.Transport.Close()
+}
+
+// Unwrap returns the recorded transport
+func (ch *channel) Unwrap() transport.Transport {
+	return ch.Transport
+}
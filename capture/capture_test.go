@@ -0,0 +1,118 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+)
+
+type testRecord struct {
+	flags  uint32
+	packet []byte
+}
+
+func readCapture(t *testing.T, path string) []testRecord {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) < 16 || !bytes.Equal(b[:8], btsnoopMagic) || binary.BigEndian.Uint32(b[12:]) != datalinkH4 {
+		t.Fatalf("invalid btsnoop header %x", b)
+	}
+	var records []testRecord
+	for b = b[16:]; len(b) > 0; {
+		l := binary.BigEndian.Uint32(b[4:])
+		records = append(records, testRecord{flags: binary.BigEndian.Uint32(b[8:]), packet: b[24 : 24+l]})
+		b = b[24+l:]
+	}
+	return records
+}
+
+func TestCaptureRecordsBothDirections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hidp.btsnoop")
+
+	tap := NewTap()
+	if err := tap.Start(Config{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	hostCtrl, ctrl := transport.Pipe()
+	hostIntr, intr := transport.Pipe()
+	ctrl, intr = tap.Wrap(ctrl, intr)
+
+	hostCtrl.Write([]byte{0x71})
+	ctrl.Read(make([]byte, 16))
+	intr.Write([]byte{0xa1, 0x02, 0, 0, 4, 0, 0, 0, 0, 0})
+	ctrl.Close()
+	intr.Close()
+	hostIntr.Close()
+	tap.Stop()
+
+	records := readCapture(t, path)
+	// connection complete, 2x request/response, 2 data packets, disconnection complete
+	if len(records) != 8 {
+		t.Fatalf("expected 8 records, got %d", len(records))
+	}
+	if records[0].flags != flagReceived|flagEvent || records[0].packet[1] != eventConnComplete {
+		t.Errorf("expected connection complete event first, got %x", records[0].packet)
+	}
+	received := records[5]
+	if received.flags != flagReceived || !bytes.Equal(received.packet[9:], []byte{0x71}) ||
+		binary.LittleEndian.Uint16(received.packet[7:]) != ctrlChannel.localCID {
+		t.Errorf("unexpected received record %x", received.packet)
+	}
+	sent := records[6]
+	if sent.flags != 0 || binary.LittleEndian.Uint16(sent.packet[7:]) != intrChannel.remoteCID {
+		t.Errorf("unexpected sent record %x", sent.packet)
+	}
+	if records[7].packet[1] != eventDisconnComplete {
+		t.Errorf("expected disconnection complete event last, got %x", records[7].packet)
+	}
+}
+
+func TestCaptureRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hidp.btsnoop")
+
+	tap := NewTap()
+	if err := tap.Start(Config{Path: path, MaxSize: 512, MaxFiles: 3}); err != nil {
+		t.Fatal(err)
+	}
+	_, ctrl := transport.Pipe()
+	hostIntr, intr := transport.Pipe()
+	ctrl, intr = tap.Wrap(ctrl, intr)
+	for i := 0; i < 40; i++ {
+		intr.Write([]byte{0xa1, 0x02, 0, 0, 4, 0, 0, 0, 0, 0})
+		hostIntr.Read(make([]byte, 16))
+	}
+	tap.Stop()
+
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 3 {
+		t.Errorf("expected 3 capture files, got %v", files)
+	}
+	for _, f := range files {
+		info, _ := os.Stat(f)
+		if info.Size() > 512 {
+			t.Errorf("%s exceeds the size cap: %d", f, info.Size())
+		}
+		// every rotated file starts with the connection setup again
+		if records := readCapture(t, f); records[0].packet[1] != eventConnComplete {
+			t.Errorf("%s does not start with the connection setup", f)
+		}
+	}
+}
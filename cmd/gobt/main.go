@@ -2,7 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"os"
 	"os/signal"
@@ -10,30 +16,305 @@ import (
 	gobt "github.com/danielpaulus/software-bluetooth-keyboard"
 	"github.com/danielpaulus/software-bluetooth-keyboard/api"
 	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth/mgmt"
+	"github.com/danielpaulus/software-bluetooth-keyboard/capture"
 	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
 	"github.com/godbus/dbus"
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 )
 
-func main() {
-	adapter := hid.NewBluetoothKeyboardAdapter()
-	go api.StartServer(adapter)
+const profilePath = "/red/potch/profile"
 
-	log.SetLevel(log.DebugLevel)
-	connIntr, err := bluetooth.Listen(bluetooth.PSMINTR, 1, false)
+// keyboardInstance is one virtual keyboard running on one adapter
+type keyboardInstance struct {
+	name     string
+	adapter  dbus.ObjectPath
+	connIntr *bluetooth.Listener
+	profile  *gobt.HidProfile
+	keyboard *hid.BluetoothKeyboardAdapter
+}
+
+// adapterAddress looks up the address of a local adapter like /org/bluez/hci0
+func adapterAddress(conn *dbus.Conn, adapter dbus.ObjectPath) (bluetooth.Bdaddr, error) {
+	v, err := conn.Object("org.bluez", adapter).GetProperty("org.bluez.Adapter1.Address")
+	if err != nil {
+		return bluetooth.Bdaddr{}, err
+	}
+	addr, ok := v.Value().(string)
+	if !ok {
+		return bluetooth.Bdaddr{}, fmt.Errorf("unexpected address of %s: %v", adapter, v)
+	}
+	return bluetooth.ParseBdaddr(addr)
+}
+
+// startInstance listens for interrupt channels on the adapter called name,
+// an empty name listens on all adapters
+func startInstance(ctx context.Context, conn *dbus.Conn, name string) (*keyboardInstance, error) {
+	instance := &keyboardInstance{name: name, keyboard: hid.NewBluetoothKeyboardAdapter()}
+	bdaddr := bluetooth.BdaddrAny
+	if name != "" {
+		instance.adapter = dbus.ObjectPath("/org/bluez/" + name)
+		var err error
+		if bdaddr, err = adapterAddress(conn, instance.adapter); err != nil {
+			return nil, fmt.Errorf("adapter %s not found: %v", name, err)
+		}
+	} else {
+		instance.name = "default"
+	}
+
+	connIntr, err := bluetooth.Listen(bluetooth.ListenOptions{
+		PSM:      bluetooth.PSMINTR,
+		Backlog:  1,
+		Bdaddr:   bdaddr,
+		Security: gobt.RequiredSecurity,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s failed: %v", bdaddr, err)
+	}
+	instance.connIntr = connIntr
+	instance.profile = gobt.NewHidProfile(ctx, conn, profilePath+"/"+instance.name, connIntr, instance.keyboard)
+	log.Infof("Keyboard %s listening on %s", instance.name, bdaddr)
+	return instance, nil
+}
+
+// parsePerAdapter parses a flag that is either a single value for all
+// adapters or a list of adapter=value pairs. Without pairs the single value
+// is returned as default.
+func parsePerAdapter(value string) (string, map[string]string, error) {
+	if !strings.Contains(value, "=") {
+		return strings.TrimSpace(value), nil, nil
+	}
+	perAdapter := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return "", nil, fmt.Errorf("invalid value %q, expected adapter=value", pair)
+		}
+		perAdapter[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return "", perAdapter, nil
+}
+
+// parseLayouts parses the -layout flag, either a single layout for all
+// adapters or a list of adapter=layout pairs. Adapters missing from the
+// list use the default layout.
+func parseLayouts(value string) (func(adapter string) *hid.Layout, error) {
+	name, names, err := parsePerAdapter(value)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = hid.DefaultLayout
+	}
+	def, err := hid.LayoutByName(name)
 	if err != nil {
-		log.Fatal("Listen failed", err, bluetooth.PSMINTR)
+		return nil, err
+	}
+	perAdapter := map[string]*hid.Layout{}
+	for adapter, name := range names {
+		if perAdapter[adapter], err = hid.LayoutByName(name); err != nil {
+			return nil, err
+		}
+	}
+	return func(adapter string) *hid.Layout {
+		if l, ok := perAdapter[adapter]; ok {
+			return l
+		}
+		return def
+	}, nil
+}
+
+// parseFallbacks parses the -fallback flag like parseLayouts. Adapters
+// missing from the list have no fallback.
+func parseFallbacks(value string) (func(adapter string) hid.Fallback, error) {
+	name, names, err := parsePerAdapter(value)
+	if err != nil {
+		return nil, err
+	}
+	var def hid.Fallback
+	if name != "" {
+		if def, err = hid.FallbackByName(name); err != nil {
+			return nil, err
+		}
 	}
+	perAdapter := map[string]hid.Fallback{}
+	for adapter, name := range names {
+		if perAdapter[adapter], err = hid.FallbackByName(name); err != nil {
+			return nil, err
+		}
+	}
+	return func(adapter string) hid.Fallback {
+		if fb, ok := perAdapter[adapter]; ok {
+			return fb
+		}
+		return def
+	}, nil
+}
 
-	hidp := gobt.NewHidProfile("/red/potch/profile", connIntr, adapter)
+// adapterSettings is applied to every adapter running a keyboard at startup
+type adapterSettings struct {
+	name         string
+	ioCapability mgmt.IOCapability
+}
+
+// adapterIndexes returns the mgmt indexes of the adapters called names,
+// an empty name stands for all adapters
+func adapterIndexes(client *mgmt.Client, names []string) ([]uint16, error) {
+	var indexes []uint16
+	for _, name := range names {
+		if name == "" {
+			return client.ReadIndexList()
+		}
+		index, err := strconv.ParseUint(strings.TrimPrefix(name, "hci"), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid adapter name %s", name)
+		}
+		indexes = append(indexes, uint16(index))
+	}
+	return indexes, nil
+}
+
+// configureAdapter makes the adapter a discoverable and pairable keyboard
+func configureAdapter(client *mgmt.Client, index uint16, settings adapterSettings) error {
+	if _, err := client.SetPowered(index, true); err != nil {
+		return fmt.Errorf("power on failed: %v", err)
+	}
+	if _, err := client.SetConnectable(index, true); err != nil {
+		return fmt.Errorf("set connectable failed: %v", err)
+	}
+	if _, err := client.SetBondable(index, true); err != nil {
+		return fmt.Errorf("set pairable failed: %v", err)
+	}
+	if err := client.SetIOCapability(index, settings.ioCapability); err != nil {
+		return fmt.Errorf("set IO capability failed: %v", err)
+	}
+	if _, err := client.SetDeviceClass(index, mgmt.KeyboardClass); err != nil {
+		return fmt.Errorf("set class of device failed: %v", err)
+	}
+	if settings.name != "" {
+		if err := client.SetLocalName(index, settings.name, ""); err != nil {
+			return fmt.Errorf("set name failed: %v", err)
+		}
+	}
+	if _, err := client.SetDiscoverable(index, true, 0); err != nil {
+		return fmt.Errorf("set discoverable failed: %v", err)
+	}
+	return nil
+}
+
+// configureAdapters applies settings to the adapters called names, failures
+// are only logged so a setup relying on main.conf keeps working
+func configureAdapters(names []string, settings adapterSettings) {
+	client, err := mgmt.Open()
+	if err != nil {
+		log.Warn("Opening the management socket failed, adapters are not configured: ", err)
+		return
+	}
+	defer client.Close()
+	indexes, err := adapterIndexes(client, names)
+	if err != nil {
+		log.Warn("Adapters are not configured: ", err)
+		return
+	}
+	for _, index := range indexes {
+		if err := configureAdapter(client, index, settings); err != nil {
+			log.Warnf("Configuring hci%d failed: %v", index, err)
+			continue
+		}
+		log.Infof("Configured hci%d as keyboard", index)
+	}
+}
+
+// capturePathFor gives every instance its own capture file when there are several
+func capturePathFor(path string, instance string, instanceCount int) string {
+	if instanceCount < 2 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + instance + ext
+}
+
+func main() {
+	adapters := flag.String("adapters", "", "comma separated list of adapters like hci0,hci1 that each run their own keyboard, by default one keyboard serves all adapters")
+	capturePath := flag.String("capture", "", "write the HIDP traffic of all sessions to this btsnoop file")
+	captureMaxSize := flag.Int64("capture-max-size", 0, "rotate the capture file when it reaches this many bytes, 0 disables rotation")
+	captureMaxFiles := flag.Int("capture-max-files", 5, "number of capture files kept when rotating")
+	configure := flag.Bool("configure-adapter", true, "make the adapters discoverable and pairable keyboards using the kernel management interface")
+	name := flag.String("name", "", "local name of the adapters, by default the name is not changed")
+	layoutDir := flag.String("layout-dir", "", "load JSON layouts converted by layoutconv from this directory")
+	layoutOverride := flag.Bool("layout-override", false, "let layouts of -layout-dir replace built-in layouts of the same name")
+	layouts := flag.String("layout", hid.DefaultLayout, "keyboard layout of the hosts, one of "+strings.Join(hid.LayoutNames(), ", ")+", or a list like hci0=de,hci1=us to choose one per adapter")
+	fallbacks := flag.String("fallback", "", "operating system of the hosts, selects how characters missing from the layout are typed: macos (needs the Unicode Hex Input source), linux, windows or skip, or a list like hci0=macos,hci1=windows. By default text with such characters is not typed")
+	ioCapability := flag.String("io-capability", "NoInputNoOutput", "IO capability used for pairing: DisplayOnly, DisplayYesNo, KeyboardOnly, NoInputNoOutput or KeyboardDisplay")
+	flag.Parse()
+
+	log.SetLevel(log.DebugLevel)
 
 	conn, err := dbus.SystemBus()
 	if err != nil {
 		log.Fatal("Failed to connect to system bus", err)
 	}
 
-	if err := conn.Export(hidp, hidp.Path(), "org.bluez.Profile1"); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	names := []string{""}
+	if *adapters != "" {
+		names = strings.Split(*adapters, ",")
+		for i := range names {
+			names[i] = strings.TrimSpace(names[i])
+		}
+	}
+	if *configure {
+		capability, err := mgmt.ParseIOCapability(*ioCapability)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configureAdapters(names, adapterSettings{name: *name, ioCapability: capability})
+	}
+	if *layoutDir != "" {
+		loaded, err := hid.LoadLayouts(*layoutDir, *layoutOverride)
+		if err != nil {
+			log.Fatal("Loading layouts failed ", err)
+		}
+		log.Infof("Loaded layouts %v", loaded)
+	}
+	layoutOf, err := parseLayouts(*layouts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fallbackOf, err := parseFallbacks(*fallbacks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := gobt.NewProfileMux(profilePath)
+	var instances []*keyboardInstance
+	var apiInstances []api.Instance
+	for _, name := range names {
+		instance, err := startInstance(ctx, conn, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		instance.keyboard.SetLayout(layoutOf(name))
+		instance.keyboard.SetFallback(fallbackOf(name))
+		mux.Handle(instance.adapter, instance.profile)
+		if *capturePath != "" {
+			err := instance.profile.Capture().Start(capture.Config{
+				Path:     capturePathFor(*capturePath, instance.name, len(names)),
+				MaxSize:  *captureMaxSize,
+				MaxFiles: *captureMaxFiles,
+			})
+			if err != nil {
+				log.Fatal("Starting capture failed", err)
+			}
+		}
+		instances = append(instances, instance)
+		apiInstances = append(apiInstances, api.Instance{Name: instance.name, Keyboard: instance.keyboard, Mouse: instance.keyboard.Mouse(), Capture: instance.profile.Capture(), Profile: instance.profile})
+	}
+	go api.StartServer(apiInstances)
+
+	if err := conn.Export(mux, mux.Path(), "org.bluez.Profile1"); err != nil {
 		log.Fatal(err)
 	}
 	log.Debug("org.bluez.Profile1 exported")
@@ -58,7 +339,7 @@ func main() {
 
 	dObjCh := make(chan *dbus.Call, 1)
 	dObj := conn.Object("org.bluez", "/org/bluez")
-	regObjCall := dObj.Go("org.bluez.ProfileManager1.RegisterProfile", 0, dObjCh, hidp.Path(), uid.String(), opts)
+	regObjCall := dObj.Go("org.bluez.ProfileManager1.RegisterProfile", 0, dObjCh, mux.Path(), uid.String(), opts)
 	log.Debug(regObjCall)
 	var r interface{}
 	if regObjCall.Err != nil {
@@ -80,19 +361,21 @@ func main() {
 		case <-sig:
 			log.Debug("Will Quit Program")
 			evloop = false
-		default:
 		}
 	}
 
 	// Probably no need of closing profile
 	log.Debug("Trying to Close Profile")
-	unregObjCall := dObj.Call("org.bluez.ProfileManager1.UnregisterProfile", 0, hidp.Path())
+	unregObjCall := dObj.Call("org.bluez.ProfileManager1.UnregisterProfile", 0, mux.Path())
 	log.Debug(unregObjCall)
 	if unregObjCall.Err != nil {
 		log.Debug(unregObjCall.Store(&r), r, regObjCall.Err)
 	}
 	log.Debug("HID Profile unregistered", "Trying to Destroy Profile Obj")
-	hidp.Close()
+	mux.Close()
+	for _, instance := range instances {
+		instance.connIntr.Close()
+	}
 
 	close(dObjCh)
 	conn.Close()
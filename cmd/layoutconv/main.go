@@ -0,0 +1,77 @@
+// layoutconv converts XKB symbols files and Windows KLC files into the JSON
+// layout format gobt loads with -layout-dir.
+//
+//	layoutconv -name de-ch -variant de /usr/share/X11/xkb/symbols/ch > de-ch.json
+//	layoutconv custom.klc > custom.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid/layoutfile"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	format := flag.String("format", "", "format of the input, xkb or klc, by default klc for .klc files and xkb otherwise")
+	variant := flag.String("variant", "", "XKB variant to convert, by default the default variant of the file")
+	xkbDir := flag.String("xkb-dir", layoutfile.DefaultXKBDir, "XKB symbols directory includes are looked up in")
+	name := flag.String("name", "", "name of the layout, by default the file name for XKB and the KBD name for KLC")
+	output := flag.String("o", "", "write the layout to this file instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	file := flag.Arg(0)
+
+	if *format == "" {
+		*format = "xkb"
+		if strings.EqualFold(filepath.Ext(file), ".klc") {
+			*format = "klc"
+		}
+	}
+	var layout *hid.Layout
+	var err error
+	switch *format {
+	case "xkb":
+		if *name == "" {
+			*name = filepath.Base(file)
+			if *variant != "" {
+				*name += "-" + *variant
+			}
+		}
+		layout, err = layoutfile.XKB{Dir: *xkbDir}.Load(file, *variant, *name)
+	case "klc":
+		layout, err = layoutfile.LoadKLC(file, *name)
+	default:
+		log.Fatalf("unknown format %s", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b, err := json.MarshalIndent(layout, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = append(b, '\n')
+	if *output == "" {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := ioutil.WriteFile(*output, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+package gobt
+
+import (
+	"encoding/binary"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	log "github.com/sirupsen/logrus"
+)
+
+// noControl is returned by handleCtrl for messages other than HID_CONTROL
+const noControl = -1
+
+func handshake(result byte) []byte {
+	return []byte{HIDPTRANSHANDSHAKE | result}
+}
+
+// reportError maps errors of the adapter to handshake result codes
+func reportError(err error) []byte {
+	switch err {
+	case hid.ErrInvalidReportID:
+		return handshake(HIDPHSHKERRINVALIDREPORTID)
+	case hid.ErrInvalidParameter:
+		return handshake(HIDPHSHKERRINVALIDPARAMETER)
+	}
+	return handshake(HIDPHSHKERRUNKNOWN)
+}
+
+// handleCtrl processes one message the host sent on the control channel. It
+// returns the reply, nil if the message has none, and the HID_CONTROL
+// operation if the message was one, noControl otherwise.
+func handleCtrl(device *hid.Connection, msg []byte) ([]byte, int) {
+	if len(msg) == 0 {
+		return nil, noControl
+	}
+	param := msg[0] & HIDPHEADERPARAMMASK
+	switch msg[0] & HIDPHEADERTRANSMASK {
+	case HIDPTRANSHANDSHAKE:
+		// only devices send handshakes, there is nothing to answer
+		log.Debugf("ctrl: handshake %#x from host", param)
+		return nil, noControl
+
+	case HIDPTRANSHIDCONTROL:
+		switch param {
+		case HIDPCTRLNOP, HIDPCTRLHARDRESET, HIDPCTRLSOFTRESET, HIDPCTRLSUSPEND, HIDPCTRLEXITSUSPEND, HIDPCTRLVIRTUALCABLEUNPLUG:
+			log.Debugf("ctrl: hid control %#x", param)
+			return nil, int(param)
+		}
+		return handshake(HIDPHSHKERRINVALIDPARAMETER), noControl
+
+	case HIDPTRANSGETREPORT:
+		typ := hid.ReportType(param & HIDPDATARTYPEMASK)
+		if typ == hid.ReportTypeOther || len(msg) < 2 {
+			return handshake(HIDPHSHKERRINVALIDPARAMETER), noControl
+		}
+		report, err := device.GetReport(typ, msg[1])
+		if err != nil {
+			return reportError(err), noControl
+		}
+		if param&HIDPGETREPORTSIZE != 0 {
+			if len(msg) < 4 {
+				return handshake(HIDPHSHKERRINVALIDPARAMETER), noControl
+			}
+			// the buffer size limits the report including its ID
+			if size := int(binary.LittleEndian.Uint16(msg[2:])); size < len(report) {
+				report = report[:size]
+			}
+		}
+		return append([]byte{HIDPTRANSDATA | byte(typ)}, report...), noControl
+
+	case HIDPTRANSSETREPORT:
+		typ := hid.ReportType(param & HIDPDATARTYPEMASK)
+		if typ == hid.ReportTypeOther || len(msg) < 2 {
+			return handshake(HIDPHSHKERRINVALIDPARAMETER), noControl
+		}
+		if err := device.SetReport(typ, msg[1], msg[2:]); err != nil {
+			return reportError(err), noControl
+		}
+		return handshake(HIDPHSHKSUCCESSFUL), noControl
+
+	case HIDPTRANSGETPROTOCOL:
+		return []byte{HIDPTRANSDATA, byte(device.Protocol())}, noControl
+
+	case HIDPTRANSSETPROTOCOL:
+		if err := device.SetProtocol(hid.Protocol(param & HIDPPROTOCOLMODEMASK)); err != nil {
+			return reportError(err), noControl
+		}
+		return handshake(HIDPHSHKSUCCESSFUL), noControl
+
+	case HIDPTRANSGETIDLE:
+		// an optional report ID selects the report, like in USB's GET_IDLE
+		var id byte
+		if len(msg) > 1 {
+			id = msg[1]
+		}
+		return []byte{HIDPTRANSDATA, device.Idle(id)}, noControl
+
+	case HIDPTRANSSETIDLE:
+		// the idle rate may be followed by a report ID, without one the
+		// rate applies to all reports
+		if len(msg) < 2 || len(msg) > 3 {
+			return handshake(HIDPHSHKERRINVALIDPARAMETER), noControl
+		}
+		var id byte
+		if len(msg) == 3 {
+			id = msg[2]
+		}
+		device.SetIdle(id, msg[1])
+		return handshake(HIDPHSHKSUCCESSFUL), noControl
+
+	case HIDPTRANSDATA:
+		// output reports sent as DATA are not acknowledged
+		typ := hid.ReportType(param & HIDPDATARTYPEMASK)
+		if typ == hid.ReportTypeOutput && len(msg) >= 2 {
+			if err := device.SetReport(typ, msg[1], msg[2:]); err != nil {
+				log.Debug("ctrl: ignoring output report ", err)
+			}
+		}
+		return nil, noControl
+	}
+
+	log.Debugf("ctrl: unsupported request %#x", msg[0])
+	return handshake(HIDPHSHKERRUNSUPPORTEDREQUEST), noControl
+}
+
+// handleIntr processes one message the host sent on the interrupt channel,
+// that are output reports like the keyboard LEDs. There is never a reply.
+func handleIntr(device *hid.Connection, msg []byte) {
+	if len(msg) < 2 || msg[0] != HIDPTRANSDATA|byte(hid.ReportTypeOutput) {
+		log.Debugf("intr: ignoring message %x", msg)
+		return
+	}
+	if err := device.SetReport(hid.ReportTypeOutput, msg[1], msg[2:]); err != nil {
+		log.Debugf("intr: ignoring output report %x: %v", msg, err)
+	}
+}
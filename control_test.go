@@ -0,0 +1,135 @@
+package gobt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+)
+
+func frame(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// every HIDP transaction on the control channel, each case starts with a
+// freshly connected keyboard. The frames are built from the HIDP
+// specification, none of them were recorded from a host. Frames captured
+// from macOS, iOS, Android, Windows and Linux hosts with the btsnoop capture
+// are not part of the tree yet.
+func TestHandleCtrl(t *testing.T) {
+	cases := []struct {
+		transaction string
+		name        string
+		request     string
+		reply       string
+		control     int
+		// leds is the expected LED output report after the request, -1 skips the check
+		leds     int
+		protocol hid.Protocol
+	}{
+		{"GET_REPORT", "get input report", "41 02", "a1 02 0000 000000000000", noControl, -1, hid.ProtocolReport},
+		{"GET_REPORT", "get feature report", "43 05", "02", noControl, -1, hid.ProtocolReport},
+		{"SET_REPORT", "set caps lock", "52 02 02", "00", noControl, 0x02, hid.ProtocolReport},
+		{"HID_CONTROL", "virtual cable unplug", "15", "", HIDPCTRLVIRTUALCABLEUNPLUG, -1, hid.ProtocolReport},
+		{"SET_PROTOCOL", "set report protocol", "71", "00", noControl, -1, hid.ProtocolReport},
+		{"SET_REPORT", "set num lock", "52 02 01", "00", noControl, 0x01, hid.ProtocolReport},
+		{"HID_CONTROL", "suspend", "13", "", HIDPCTRLSUSPEND, -1, hid.ProtocolReport},
+		{"HID_CONTROL", "exit suspend", "14", "", HIDPCTRLEXITSUSPEND, -1, hid.ProtocolReport},
+		{"GET_PROTOCOL", "get protocol", "60", "a0 01", noControl, -1, hid.ProtocolReport},
+		{"SET_IDLE", "set idle", "90 00", "00", noControl, -1, hid.ProtocolReport},
+		{"SET_REPORT", "set report without data", "52 02", "04", noControl, 0x00, hid.ProtocolReport},
+		{"GET_REPORT", "get led report", "42 02", "a2 02 00", noControl, 0x00, hid.ProtocolReport},
+		{"SET_PROTOCOL", "set boot protocol", "70", "00", noControl, -1, hid.ProtocolBoot},
+		{"GET_REPORT", "get report with buffer size", "49 02 0500", "a1 02 0000 0000", noControl, -1, hid.ProtocolReport},
+		{"GET_REPORT", "get mouse report", "41 01", "a1 01 00000000", noControl, -1, hid.ProtocolReport},
+		{"GET_IDLE", "get idle", "80", "a0 00", noControl, -1, hid.ProtocolReport},
+		{"DATA", "output report as data", "a2 02 04", "", noControl, 0x04, hid.ProtocolReport},
+		{"SET_REPORT", "set input report", "51 02 0000 000000000000", "02", noControl, -1, hid.ProtocolReport},
+		{"GET_REPORT", "get unknown report id", "41 07", "02", noControl, -1, hid.ProtocolReport},
+		{"GET_REPORT", "get report of type other", "40 02", "04", noControl, -1, hid.ProtocolReport},
+		{"GET_REPORT", "get report without report id", "41", "04", noControl, -1, hid.ProtocolReport},
+		{"DATC", "deprecated datc", "b2 02 01", "03", noControl, -1, hid.ProtocolReport},
+		{"reserved", "reserved message type", "20", "03", noControl, -1, hid.ProtocolReport},
+		{"HID_CONTROL", "invalid hid control", "1f", "04", noControl, -1, hid.ProtocolReport},
+		{"HANDSHAKE", "handshake from host", "00", "", noControl, -1, hid.ProtocolReport},
+	}
+	for _, c := range cases {
+		t.Run(c.transaction+"/"+c.name, func(t *testing.T) {
+			device := hid.NewBluetoothKeyboardAdapter().NewConnection(hid.Host{})
+			reply, control := handleCtrl(device, frame(c.request))
+			if !bytes.Equal(reply, frame(c.reply)) {
+				t.Errorf("expected reply %s, got %x", c.reply, reply)
+			}
+			if control != c.control {
+				t.Errorf("expected hid control %d, got %d", c.control, control)
+			}
+			if p := device.Protocol(); p != c.protocol {
+				t.Errorf("expected protocol %d, got %d", c.protocol, p)
+			}
+			if c.leds >= 0 {
+				report, err := device.GetReport(hid.ReportTypeOutput, hid.ReportIDKeyboard)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report[1] != byte(c.leds) {
+					t.Errorf("expected leds %#x, got %#x", c.leds, report[1])
+				}
+			}
+		})
+	}
+}
+
+func TestSetIdleIsReportedByGetIdle(t *testing.T) {
+	device := hid.NewBluetoothKeyboardAdapter().NewConnection(hid.Host{})
+	if reply, _ := handleCtrl(device, frame("90 7d")); !bytes.Equal(reply, frame("00")) {
+		t.Fatalf("set idle failed: %x", reply)
+	}
+	if reply, _ := handleCtrl(device, frame("80")); !bytes.Equal(reply, frame("a0 7d")) {
+		t.Errorf("expected idle rate 0x7d, got %x", reply)
+	}
+}
+
+func TestBootProtocol(t *testing.T) {
+	device := hid.NewBluetoothKeyboardAdapter().NewConnection(hid.Host{})
+	steps := []struct {
+		request string
+		reply   string
+	}{
+		{"70", "00"},
+		{"60", "a0 00"},
+		// boot reports use the fixed IDs 1 for the keyboard and 2 for the mouse
+		{"41 01", "a1 01 0000 000000000000"},
+		{"41 02", "a1 02 000000"},
+		{"52 01 02", "00"},
+		{"52 02 02", "02"},
+		{"71", "00"},
+		{"60", "a0 01"},
+		{"41 02", "a1 02 0000 000000000000"},
+	}
+	for _, step := range steps {
+		if reply, _ := handleCtrl(device, frame(step.request)); !bytes.Equal(reply, frame(step.reply)) {
+			t.Errorf("%s: expected reply %s, got %x", step.request, step.reply, reply)
+		}
+	}
+	if !device.LEDs().CapsLock {
+		t.Error("caps lock set in boot protocol mode was lost")
+	}
+}
+
+func TestIdlePerReportID(t *testing.T) {
+	device := hid.NewBluetoothKeyboardAdapter().NewConnection(hid.Host{})
+	handleCtrl(device, frame("90 19"))
+	handleCtrl(device, frame("90 7d 02"))
+	if reply, _ := handleCtrl(device, frame("80 02")); !bytes.Equal(reply, frame("a0 7d")) {
+		t.Errorf("expected keyboard idle rate 0x7d, got %x", reply)
+	}
+	if reply, _ := handleCtrl(device, frame("80 01")); !bytes.Equal(reply, frame("a0 19")) {
+		t.Errorf("expected mouse idle rate 0x19, got %x", reply)
+	}
+}
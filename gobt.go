@@ -1,103 +1,256 @@
 package gobt
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
 	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
 	log "github.com/sirupsen/logrus"
 )
 
-type GoBtPollState byte
-
-const (
-	STOP GoBtPollState = iota
-)
-
+// HIDP message header values from the Bluetooth HID profile specification
 const (
 	HIDPHEADERTRANSMASK = 0xf0
+	HIDPHEADERPARAMMASK = 0x0f
 
 	HIDPTRANSHANDSHAKE   = 0x00
-	HIDPTRANSSETPROTOCOL = 0x60
+	HIDPTRANSHIDCONTROL  = 0x10
+	HIDPTRANSGETREPORT   = 0x40
+	HIDPTRANSSETREPORT   = 0x50
+	HIDPTRANSGETPROTOCOL = 0x60
+	HIDPTRANSSETPROTOCOL = 0x70
+	HIDPTRANSGETIDLE     = 0x80
+	HIDPTRANSSETIDLE     = 0x90
 	HIDPTRANSDATA        = 0xa0
+	HIDPTRANSDATC        = 0xb0
 
-	HIDPHSHKSUCCESSFUL = 0x00
-	HIDPHSHKERRUNKNOWN = 0x0e
+	HIDPHSHKSUCCESSFUL            = 0x00
+	HIDPHSHKNOTREADY              = 0x01
+	HIDPHSHKERRINVALIDREPORTID    = 0x02
+	HIDPHSHKERRUNSUPPORTEDREQUEST = 0x03
+	HIDPHSHKERRINVALIDPARAMETER   = 0x04
+	HIDPHSHKERRUNKNOWN            = 0x0e
+	HIDPHSHKERRFATAL              = 0x0f
+
+	HIDPCTRLNOP                = 0x00
+	HIDPCTRLHARDRESET          = 0x01
+	HIDPCTRLSOFTRESET          = 0x02
+	HIDPCTRLSUSPEND            = 0x03
+	HIDPCTRLEXITSUSPEND        = 0x04
+	HIDPCTRLVIRTUALCABLEUNPLUG = 0x05
+
+	// HIDPGETREPORTSIZE is set when a GET_REPORT carries the host's buffer size
+	HIDPGETREPORTSIZE    = 0x08
+	HIDPDATARTYPEMASK    = 0x03
+	HIDPPROTOCOLMODEMASK = 0x01
 )
 
+// helloDelay gives the host time to settle after the hello messages
+var helloDelay = 1 * time.Second
+
+// GoBt is the HID session with one host, see session.go for its states
 type GoBt struct {
-	sintr *bluetooth.Bluetooth
-	sctrl *bluetooth.Bluetooth
+	// sintr and sctrl are nil until start, chanMu guards setting them
+	chanMu sync.Mutex
+	sintr  transport.Transport
+	sctrl  transport.Transport
 
-	cctl            chan GoBtPollState
-	keyboardAdapter *hid.BluetoothKeyboardAdapter
+	ctx    context.Context
+	cancel context.CancelFunc
+	// conn carries the reports to this session's host
+	conn *hid.Connection
+	// unplugged is set to 1 before the session ends because of a virtual cable unplug
+	unplugged int32
+
+	stateMu sync.Mutex
+	state   hid.SessionState
 }
 
-func NewGoBt(sintr, sctrl *bluetooth.Bluetooth, keyboardAdapter *hid.BluetoothKeyboardAdapter) *GoBt {
-	gobt := GoBt{
-		sintr:           sintr,
-		sctrl:           sctrl,
-		cctl:            make(chan GoBtPollState, 2),
-		keyboardAdapter: keyboardAdapter,
+// NewGoBt starts a HID session with host on the given interrupt and control
+// sockets. The session ends and closes both sockets when ctx is cancelled,
+// when Close is called or when the host drops the control channel.
+func NewGoBt(ctx context.Context, host hid.Host, sintr, sctrl transport.Transport, keyboardAdapter *hid.BluetoothKeyboardAdapter) *GoBt {
+	gb := newSession(ctx, host, keyboardAdapter)
+	if !gb.start(sintr, sctrl) {
+		return nil
 	}
+	return gb
+}
 
-	keyboardAdapter.SetBtConnection(sintr)
+// newSession creates a session in the accepting state, it waits for its
+// channels to be passed to start
+func newSession(ctx context.Context, host hid.Host, keyboardAdapter *hid.BluetoothKeyboardAdapter) *GoBt {
+	ctx, cancel := context.WithCancel(ctx)
+	gb := &GoBt{
+		ctx:    ctx,
+		cancel: cancel,
+		conn:   keyboardAdapter.NewConnection(host),
+		state:  hid.SessionClosed,
+	}
+	gb.handle(eventAccepting)
+
+	go func() {
+		<-gb.ctx.Done()
+		// closing the sockets unblocks any pending Read in the event loop
+		if sintr, sctrl := gb.channels(); sctrl != nil {
+			sctrl.Close()
+			sintr.Close()
+		}
+		// reports held back for a suspended host now fail instead of waiting forever
+		gb.conn.Resume()
+		gb.handle(eventClosed)
+	}()
+	return gb
+}
+
+// channels returns the sockets passed to start, nil while accepting
+func (gb *GoBt) channels() (sintr, sctrl transport.Transport) {
+	gb.chanMu.Lock()
+	defer gb.chanMu.Unlock()
+	return gb.sintr, gb.sctrl
+}
+
+// start runs the session on its channels, it returns false and closes the
+// session if the host could not be greeted
+func (gb *GoBt) start(sintr, sctrl transport.Transport) bool {
+	gb.chanMu.Lock()
+	if gb.ctx.Err() != nil {
+		// the sockets would be left open after ctx was seen done
+		gb.chanMu.Unlock()
+		return false
+	}
+	gb.sintr, gb.sctrl = sintr, sctrl
+	gb.chanMu.Unlock()
+	if !gb.handle(eventConnected) {
+		// the session was closed while accepting
+		return false
+	}
+	gb.conn.Attach(sintr)
 
 	log.Debug("Sending hello on ctrl channel")
-	if _, err := gobt.sctrl.Write([]byte{0xa1, 0x13, 0x03}); err != nil {
+	if _, err := gb.sctrl.Write([]byte{0xa1, 0x13, 0x03}); err != nil {
 		log.Debug("Failure on Sending Hello on Ctrl 1", err)
-		return nil
+		gb.fail()
+		return false
 	}
-	if _, err := gobt.sctrl.Write([]byte{0xa1, 0x13, 0x02}); err != nil {
+	if _, err := gb.sctrl.Write([]byte{0xa1, 0x13, 0x02}); err != nil {
 		log.Debug("Failure on Sending Hello on Ctrl 2", err)
-		return nil
+		gb.fail()
+		return false
+	}
+	time.Sleep(helloDelay)
+
+	if !gb.handle(eventHelloSent) {
+		return false
 	}
-	time.Sleep(1 * time.Second)
+	go gb.startProcessCtrlEvent()
+	go gb.startProcessIntrEvent()
+	return true
+}
 
-	go gobt.startProcessCtrlEvent()
-	return &gobt
+// fail ends the session because of a socket error
+func (gb *GoBt) fail() {
+	gb.handle(eventSocketError)
+	gb.cancel()
 }
 
 func (gb *GoBt) startProcessCtrlEvent() {
+	defer gb.fail()
+	r := make([]byte, bluetooth.BUFSIZE)
 	for {
-		select {
-		case <-gb.cctl:
+		d, err := gb.sctrl.Read(r)
+		if gb.ctx.Err() != nil {
 			log.Debug("Will Quit GoBt Process loop")
 			return
-		default:
-			r := make([]byte, bluetooth.BUFSIZE)
-			d, err := gb.sctrl.Read(r)
-			if err != nil || d < 1 {
-				log.Debug("GoBt.procesCtrlEvent: no data received - quitting event loop")
-				gb.Close()
-				return
-			}
+		}
+		if err != nil || d < 1 {
+			log.Debug("GoBt.procesCtrlEvent: no data received - quitting event loop")
+			return
+		}
 
-			hsk := []byte{HIDPTRANSHANDSHAKE}
-			msgTyp := r[0] & HIDPHEADERTRANSMASK
-
-			switch {
-			case (msgTyp & HIDPTRANSSETPROTOCOL) != 0:
-				log.Debug("GoBt.procesCtrlEvent: handshake set protocol")
-				hsk[0] |= HIDPHSHKSUCCESSFUL
-				if _, err := gb.sctrl.Write(hsk); err != nil {
-					log.Debug("GoBt.procesCtrlEvent: handshake set protocol: failure on reply")
-				}
-			case (msgTyp & HIDPTRANSDATA) != 0:
-				log.Debug("GoBt.procesCtrlEvent: handshake data")
-			default:
-				log.Debug("GoBt.procesCtrlEvent: unknown handshake message")
-				hsk[0] |= HIDPHSHKERRUNKNOWN
-				gb.sctrl.Write(hsk)
+		reply, op := handleCtrl(gb.conn, r[:d])
+		if reply != nil {
+			if _, err := gb.sctrl.Write(reply); err != nil {
+				log.Debug("GoBt.procesCtrlEvent: failure on reply ", err)
+			}
+		}
+		switch op {
+		case HIDPCTRLSUSPEND:
+			// hold reports back before the state says so
+			gb.conn.Suspend()
+			if !gb.handle(eventSuspend) {
+				gb.conn.Resume()
+			}
+		case HIDPCTRLEXITSUSPEND:
+			if gb.handle(eventExitSuspend) {
+				gb.conn.Resume()
 			}
+		case HIDPCTRLVIRTUALCABLEUNPLUG:
+			log.Info("Host unplugged the virtual cable")
+			atomic.StoreInt32(&gb.unplugged, 1)
+			gb.handle(eventUnplug)
+			gb.cancel()
+			return
 		}
 	}
 }
 
-func (gb *GoBt) Close() {
+// startProcessIntrEvent reads the output reports the host sends on the
+// interrupt channel
+func (gb *GoBt) startProcessIntrEvent() {
+	defer gb.fail()
+	r := make([]byte, bluetooth.BUFSIZE)
+	for {
+		d, err := gb.sintr.Read(r)
+		if gb.ctx.Err() != nil {
+			return
+		}
+		if err != nil || d < 1 {
+			log.Debug("GoBt.processIntrEvent: no data received - quitting event loop")
+			return
+		}
+		handleIntr(gb.conn, r[:d])
+	}
+}
+
+// Host returns the host of the session
+func (gb *GoBt) Host() hid.Host {
+	return gb.conn.Host()
+}
+
+// Done is closed once the session is ending
+func (gb *GoBt) Done() <-chan struct{} {
+	return gb.ctx.Done()
+}
+
+// Unplugged reports whether the session ended with a virtual cable unplug,
+// sent by the host or by Unplug
+func (gb *GoBt) Unplugged() bool {
+	return atomic.LoadInt32(&gb.unplugged) == 1
+}
 
-	log.Debug("Stopped HIDevices")
+// Unplug tells the host that the virtual cable was unplugged and ends the
+// session. The host is expected to remove the pairing like we do.
+func (gb *GoBt) Unplug() error {
+	atomic.StoreInt32(&gb.unplugged, 1)
+	_, err := gb.sctrl.Write([]byte{HIDPTRANSHIDCONTROL | HIDPCTRLVIRTUALCABLEUNPLUG})
+	gb.handle(eventUnplug)
+	gb.cancel()
+	return err
+}
 
-	log.Debug("Trying to Stop GoBt evevnt loop")
-	gb.cctl <- STOP
+// Close ends the session. It is safe to call Close more than once.
+func (gb *GoBt) Close() {
+	log.Debug("Trying to Stop GoBt event loop")
+	prev, _ := gb.transition(eventDisconnect)
+	gb.cancel()
+	if prev == hid.SessionAccepting {
+		// the session never got its channels, report it closed right away
+		// instead of when ctx is seen done
+		gb.handle(eventClosed)
+	}
 }
@@ -0,0 +1,204 @@
+package gobt
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+)
+
+type testHost struct {
+	intr transport.Transport
+	ctrl transport.Transport
+}
+
+func (h *testHost) expect(t *testing.T, ch transport.Transport, expected []byte) {
+	t.Helper()
+	buf := make([]byte, 64)
+	n, err := ch.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], expected) {
+		t.Errorf("expected %x, got %x", expected, buf[:n])
+	}
+}
+
+func newTestSession(t *testing.T) (*GoBt, *testHost, *hid.BluetoothKeyboardAdapter) {
+	t.Helper()
+	return newTestSessionWith(t, hid.NewBluetoothKeyboardAdapter(), testHostID)
+}
+
+var testHostID = hid.Host{Address: "AA:BB:CC:DD:EE:01", Device: "/org/bluez/hci0/dev_AA_BB_CC_DD_EE_01"}
+
+func newTestSessionWith(t *testing.T, adapter *hid.BluetoothKeyboardAdapter, id hid.Host) (*GoBt, *testHost, *hid.BluetoothKeyboardAdapter) {
+	t.Helper()
+	helloDelay = 0
+
+	hostIntr, sintr, err := transport.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostCtrl, sctrl, err := transport.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb := NewGoBt(context.Background(), id, sintr, sctrl, adapter)
+	if gb == nil {
+		t.Fatal("session could not be started")
+	}
+	host := &testHost{intr: hostIntr, ctrl: hostCtrl}
+	host.expect(t, hostCtrl, []byte{0xa1, 0x13, 0x03})
+	host.expect(t, hostCtrl, []byte{0xa1, 0x13, 0x02})
+	return gb, host, adapter
+}
+
+func TestSessionTypesOnInterruptChannel(t *testing.T) {
+	gb, host, adapter := newTestSession(t)
+	defer gb.Close()
+
+	if !adapter.Status().IsReady {
+		t.Error("adapter should be ready after the session started")
+	}
+	if err := adapter.TypeKey("KEY_ENTER"); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, host.intr, []byte{0xa1, 0x02, 0, 0, 0x28, 0, 0, 0, 0, 0})
+	host.expect(t, host.intr, []byte{0xa1, 0x02, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+func TestSessionsPerHost(t *testing.T) {
+	first, firstHost, adapter := newTestSession(t)
+	defer first.Close()
+	second, secondHost, _ := newTestSessionWith(t, adapter, hid.Host{Address: "AA:BB:CC:DD:EE:02", Device: "/org/bluez/hci0/dev_AA_BB_CC_DD_EE_02"})
+	defer second.Close()
+
+	// both hosts get the key
+	if err := adapter.TypeKey("KEY_A"); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []*testHost{firstHost, secondHost} {
+		host.expect(t, host.intr, []byte{0xa1, 0x02, 0, 0, 0x04, 0, 0, 0, 0, 0})
+		host.expect(t, host.intr, []byte{0xa1, 0x02, 0, 0, 0, 0, 0, 0, 0, 0})
+	}
+
+	// only the first host gets the next one
+	keyboard, err := adapter.Target(testHostID.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyboard.TypeKey("KEY_B"); err != nil {
+		t.Fatal(err)
+	}
+	firstHost.expect(t, firstHost.intr, []byte{0xa1, 0x02, 0, 0, 0x05, 0, 0, 0, 0, 0})
+	firstHost.expect(t, firstHost.intr, []byte{0xa1, 0x02, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	// every host negotiates its own protocol mode
+	secondHost.ctrl.Write([]byte{HIDPTRANSSETPROTOCOL | byte(hid.ProtocolBoot)})
+	secondHost.expect(t, secondHost.ctrl, []byte{HIDPTRANSHANDSHAKE | HIDPHSHKSUCCESSFUL})
+	if err := adapter.TypeKey("KEY_C"); err != nil {
+		t.Fatal(err)
+	}
+	firstHost.expect(t, firstHost.intr, []byte{0xa1, hid.ReportIDKeyboard, 0, 0, 0x06, 0, 0, 0, 0, 0})
+	secondHost.expect(t, secondHost.intr, []byte{0xa1, hid.BootReportIDKeyboard, 0, 0, 0x06, 0, 0, 0, 0, 0})
+
+	if hosts := adapter.Status().Hosts; len(hosts) != 2 {
+		t.Errorf("expected two hosts in the status, got %+v", hosts)
+	}
+}
+
+func TestSessionEndsWhenHostHangsUp(t *testing.T) {
+	gb, host, _ := newTestSession(t)
+
+	host.ctrl.Close()
+	select {
+	case <-gb.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session did not end after the host closed the control channel")
+	}
+}
+
+func TestSessionRepliesOnControlChannel(t *testing.T) {
+	gb, host, _ := newTestSession(t)
+	defer gb.Close()
+
+	host.ctrl.Write([]byte{HIDPTRANSGETPROTOCOL})
+	host.expect(t, host.ctrl, []byte{HIDPTRANSDATA, byte(hid.ProtocolReport)})
+
+	host.ctrl.Write([]byte{HIDPTRANSHIDCONTROL | HIDPCTRLVIRTUALCABLEUNPLUG})
+	select {
+	case <-gb.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session did not end after the virtual cable unplug")
+	}
+	if !gb.Unplugged() {
+		t.Error("session should report the unplug")
+	}
+}
+
+func TestSessionReadsLEDs(t *testing.T) {
+	gb, host, adapter := newTestSession(t)
+	defer gb.Close()
+
+	// Windows uses SET_REPORT on the control channel
+	host.ctrl.Write([]byte{HIDPTRANSSETREPORT | byte(hid.ReportTypeOutput), hid.ReportIDKeyboard, hid.LEDCapsLock | hid.LEDNumLock})
+	host.expect(t, host.ctrl, []byte{HIDPTRANSHANDSHAKE | HIDPHSHKSUCCESSFUL})
+	if leds := adapter.Status().LEDs; !leds.CapsLock || !leds.NumLock || leds.ScrollLock {
+		t.Errorf("unexpected leds %+v", leds)
+	}
+
+	// Linux sends the LEDs on the interrupt channel
+	host.intr.Write([]byte{0xa2, hid.ReportIDKeyboard, hid.LEDScrollLock})
+	deadline := time.Now().Add(time.Second)
+	for !adapter.Status().LEDs.ScrollLock && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if leds := adapter.Status().LEDs; leds.CapsLock || leds.NumLock || !leds.ScrollLock {
+		t.Errorf("unexpected leds %+v", leds)
+	}
+}
+
+func TestSessionHoldsReportsWhileSuspended(t *testing.T) {
+	gb, host, adapter := newTestSession(t)
+	defer gb.Close()
+
+	host.ctrl.Write([]byte{HIDPTRANSHIDCONTROL | HIDPCTRLSUSPEND})
+	waitForState(t, adapter, hid.SessionSuspended)
+
+	typed := make(chan error, 1)
+	go func() {
+		typed <- adapter.TypeKey("KEY_A")
+	}()
+	select {
+	case <-typed:
+		t.Fatal("key was sent to a suspended host")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	host.ctrl.Write([]byte{HIDPTRANSHIDCONTROL | HIDPCTRLEXITSUSPEND})
+	host.expect(t, host.intr, []byte{0xa1, 0x02, 0, 0, 0x04, 0, 0, 0, 0, 0})
+	host.expect(t, host.intr, []byte{0xa1, 0x02, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err := <-typed; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnplugFromOurSide(t *testing.T) {
+	gb, host, _ := newTestSession(t)
+
+	if err := gb.Unplug(); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, host.ctrl, []byte{HIDPTRANSHIDCONTROL | HIDPCTRLVIRTUALCABLEUNPLUG})
+	select {
+	case <-gb.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session did not end after unplugging")
+	}
+	if !gb.Unplugged() {
+		t.Error("session should report the unplug")
+	}
+}
@@ -0,0 +1,21 @@
+package hid
+
+// appleT maps keys of Apple keyboards without a standard usage to their bit
+// in the Apple report. The descriptor gives each bit a usage on the top case
+// page (0xFF) or the Apple keyboard page (0xFF01).
+var appleT = map[string]int{
+	// Globe on iOS, fn on macOS: held with other keys it opens the emoji
+	// picker, quick note (Globe+Q), Control Center (Globe+C) or the dock
+	// (Globe+A)
+	"KEY_FN":             0,
+	"KEY_SPOTLIGHT":      1,
+	"KEY_KBDILLUMTOGGLE": 2,
+	"KEY_KBDILLUMUP":     3,
+	"KEY_KBDILLUMDOWN":   4,
+}
+
+// appleReport returns the Apple report with the given bits held. Boot
+// protocol has no Apple report.
+func appleReport(keys byte) []byte {
+	return []byte{ReportIDApple, keys}
+}
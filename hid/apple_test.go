@@ -0,0 +1,27 @@
+package hid
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGlobeChord(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	conn, rec := readyConnection(t, adapter, Host{})
+
+	// quick note, the Globe key has to be held before Q goes down
+	if err := adapter.SendChord("globe+q"); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec, "a10501", "a1020000140000000000", "a10500", "a1020000000000000000")
+
+	conn.KeyDown("KEY_KBDILLUMUP")
+	conn.KeyDown("KEY_FN")
+	report, err := conn.GetReport(ReportTypeInput, ReportIDApple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%x", report); got != "0509" {
+		t.Errorf("GET_REPORT should return the held Apple keys, got %s", got)
+	}
+}
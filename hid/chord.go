@@ -0,0 +1,192 @@
+package hid
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// chordAliases maps friendly names used in chord expressions to key names
+var chordAliases = map[string]string{
+	"ctrl":    "KEY_LEFTCTRL",
+	"control": "KEY_LEFTCTRL",
+	"shift":   "KEY_LEFTSHIFT",
+	"alt":     "KEY_LEFTALT",
+	"opt":     "KEY_LEFTALT",
+	"option":  "KEY_LEFTALT",
+	"altgr":   "KEY_RIGHTALT",
+	"cmd":     "KEY_LEFTMETA",
+	"command": "KEY_LEFTMETA",
+	"win":     "KEY_LEFTMETA",
+	"super":   "KEY_LEFTMETA",
+	"meta":    "KEY_LEFTMETA",
+	"gui":     "KEY_LEFTMETA",
+	"rctrl":   "KEY_RIGHTCTRL",
+	"rshift":  "KEY_RIGHTSHIFT",
+	"ralt":    "KEY_RIGHTALT",
+	"rcmd":    "KEY_RIGHTMETA",
+	"fn":      "KEY_FN",
+	"globe":   "KEY_FN",
+
+	"return": "KEY_ENTER",
+	"escape": "KEY_ESC",
+	"del":    "KEY_DELETE",
+	"ins":    "KEY_INSERT",
+	"eject":  "KEY_EJECTCD",
+	"lock":   "KEY_SCREENLOCK",
+	"pgup":   "KEY_PAGEUP",
+	"pgdn":   "KEY_PAGEDOWN",
+	"period": "KEY_DOT",
+	"-":      "KEY_MINUS",
+	"=":      "KEY_EQUAL",
+	".":      "KEY_DOT",
+	"/":      "KEY_SLASH",
+	";":      "KEY_SEMICOLON",
+	"'":      "KEY_APOSTROPHE",
+	"`":      "KEY_GRAVE",
+	"[":      "KEY_LEFTBRACE",
+	"]":      "KEY_RIGHTBRACE",
+	"\\":     "KEY_BACKSLASH",
+
+	// the separators of chord expressions are keys too, plus is the key
+	// with + on US layouts
+	"plus":  "KEY_EQUAL",
+	"+":     "KEY_EQUAL",
+	"comma": "KEY_COMMA",
+}
+
+// Chord is a set of keys pressed together, e.g. cmd+shift+3
+type Chord struct {
+	// Keys are the key names in the order they appear in the expression
+	Keys []string
+}
+
+// ParseChords parses a sequence of chords separated by commas. Keys of a
+// chord are joined with +, e.g. "ctrl+a, ctrl+c". Keys are friendly names
+// like cmd, opt, win or enter, names without the KEY_ prefix like a or f5,
+// or key names like KEY_LEFTMETA, all case insensitive. The + and , keys are
+// called plus and comma, a chord ending in ++ like ctrl++ presses + last.
+func ParseChords(expr string) ([]Chord, error) {
+	var chords []Chord
+	for _, part := range strings.Split(expr, ",") {
+		chord, err := ParseChord(part)
+		if err != nil {
+			return nil, err
+		}
+		chords = append(chords, chord)
+	}
+	return chords, nil
+}
+
+// ParseChord parses a single chord like ctrl+alt+delete
+func ParseChord(expr string) (Chord, error) {
+	var chord Chord
+	keys := 0
+	names := strings.Split(expr, "+")
+	if trimmed := strings.TrimSpace(expr); trimmed == "+" {
+		names = []string{"+"}
+	} else if strings.HasSuffix(trimmed, "++") {
+		names = append(strings.Split(strings.TrimSuffix(trimmed, "++"), "+"), "+")
+	}
+	for _, name := range names {
+		key, err := chordKey(name)
+		if err != nil {
+			return Chord{}, fmt.Errorf("chord %q: %v", strings.TrimSpace(expr), err)
+		}
+		if _, kind := Convert(key); kind == FUNC {
+			keys++
+		}
+		chord.Keys = append(chord.Keys, key)
+	}
+	if keys > keySlots {
+		return Chord{}, fmt.Errorf("chord %q: more than %d keys", strings.TrimSpace(expr), keySlots)
+	}
+	return chord, nil
+}
+
+func chordKey(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	if key, ok := chordAliases[strings.ToLower(name)]; ok {
+		return key, nil
+	}
+	key := strings.ToUpper(name)
+	if !strings.HasPrefix(key, "KEY_") {
+		key = "KEY_" + key
+	}
+	if !IsSupported(key) {
+		return "", fmt.Errorf("unsupported key %q", name)
+	}
+	return key, nil
+}
+
+// Report returns the 8 byte keyboard report with all keys of the chord held
+func (c Chord) Report() []byte {
+	var k keyState
+	for _, key := range c.Keys {
+		k.down(key)
+	}
+	return k.report()
+}
+
+func (c Chord) String() string {
+	return strings.Join(c.Keys, "+")
+}
+
+// sendChord presses all keys of ch in one report and releases them in the next
+func (c *Connection) sendChord(ch Chord) error {
+	err := c.update(func(k *keyState) (bool, error) {
+		changed := false
+		for _, key := range ch.Keys {
+			down, err := k.down(key)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || down
+		}
+		return changed, nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.update(func(k *keyState) (bool, error) {
+		changed := false
+		for _, key := range ch.Keys {
+			up, err := k.up(key)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || up
+		}
+		return changed, nil
+	})
+}
+
+// SendChord parses expr with ParseChords and sends the chords one after the
+// other to every host
+func (ba *BluetoothKeyboardAdapter) SendChord(expr string) error {
+	return ba.sendChord("", expr)
+}
+
+func (ba *BluetoothKeyboardAdapter) sendChord(target string, expr string) error {
+	chords, err := ParseChords(expr)
+	if err != nil {
+		return err
+	}
+	log.Infof("Sending chords %v", chords)
+	return ba.each(target, func(c *Connection) error {
+		for _, ch := range chords {
+			if err := c.sendChord(ch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (tk *targetKeyboard) SendChord(expr string) error {
+	return tk.adapter.sendChord(tk.target, expr)
+}
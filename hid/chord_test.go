@@ -0,0 +1,56 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseChords(t *testing.T) {
+	cases := []struct {
+		expr    string
+		reports [][]byte
+	}{
+		{"cmd+h", [][]byte{{0x08, 0, 11, 0, 0, 0, 0, 0}}},
+		{"cmd+shift+3", [][]byte{{0x0a, 0, 32, 0, 0, 0, 0, 0}}},
+		{"Ctrl+Alt+Delete", [][]byte{{0x05, 0, 76, 0, 0, 0, 0, 0}}},
+		{"ctrl+a, ctrl+c", [][]byte{{0x01, 0, 4, 0, 0, 0, 0, 0}, {0x01, 0, 6, 0, 0, 0, 0, 0}}},
+		{"win+KEY_L", [][]byte{{0x08, 0, 15, 0, 0, 0, 0, 0}}},
+		{"opt+f5", [][]byte{{0x04, 0, 62, 0, 0, 0, 0, 0}}},
+		{"enter", [][]byte{{0, 0, 40, 0, 0, 0, 0, 0}}},
+		{"ctrl++", [][]byte{{0x01, 0, 46, 0, 0, 0, 0, 0}}},
+		{"ctrl+plus, cmd+comma", [][]byte{{0x01, 0, 46, 0, 0, 0, 0, 0}, {0x08, 0, 54, 0, 0, 0, 0, 0}}},
+		{"+", [][]byte{{0, 0, 46, 0, 0, 0, 0, 0}}},
+	}
+	for _, c := range cases {
+		chords, err := ParseChords(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if len(chords) != len(c.reports) {
+			t.Errorf("%s: expected %d chords, got %v", c.expr, len(c.reports), chords)
+			continue
+		}
+		for i, chord := range chords {
+			if report := chord.Report(); !bytes.Equal(report, c.reports[i]) {
+				t.Errorf("%s: expected report %x, got %x", c.expr, c.reports[i], report)
+			}
+		}
+	}
+
+	for _, expr := range []string{"", "ctrl+", "cmd+nope", "a+b+c+d+e+f+g", "ctrl+++", "ctrl+,"} {
+		if _, err := ParseChords(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestSendChord(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	_, rec := readyConnection(t, adapter, Host{})
+
+	if err := adapter.SendChord("cmd+h"); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec, "a10208000b0000000000", "a1020000000000000000")
+}
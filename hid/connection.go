@@ -0,0 +1,190 @@
+package hid
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownHost is returned when a target matches no connected host
+var ErrUnknownHost = errors.New("no connected host matches the target")
+
+// ErrNotReady is returned when no host is ready to receive reports, nothing
+// was sent
+var ErrNotReady = errors.New("no connected host is ready")
+
+// Host identifies a host connected to the keyboard
+type Host struct {
+	// Address is the Bluetooth address of the host, e.g. AA:BB:CC:DD:EE:FF
+	Address string `json:"address"`
+	// Device is the BlueZ object path of the host, it may be empty
+	Device string `json:"device,omitempty"`
+}
+
+// Matches reports whether target is the address or the device path of h,
+// addresses are compared case insensitive
+func (h Host) Matches(target string) bool {
+	if target == "" {
+		return false
+	}
+	return strings.EqualFold(target, h.Address) || target == h.Device
+}
+
+// HostStatus is the status of the connection to one host
+type HostStatus struct {
+	Host     Host         `json:"host"`
+	State    SessionState `json:"state"`
+	Protocol Protocol     `json:"protocol"`
+	// QueueDepth is the number of bytes in the send queue of the connection
+	QueueDepth int         `json:"queueDepth"`
+	LEDs       LEDs        `json:"leds"`
+	Pacing     PacingStats `json:"pacing"`
+}
+
+// Connection is the interrupt channel to one host together with the state
+// the host negotiated on its control channel. Every HIDP session has its own
+// connection, so several hosts can use the keyboard at the same time.
+type Connection struct {
+	adapter *BluetoothKeyboardAdapter
+	host    Host
+
+	// sendMu keeps the order of key reports, it is taken before mu
+	sendMu sync.Mutex
+	mu     sync.Mutex
+	out    transport.Transport
+	queue  outQueuer
+	// pacer slows reports down when the send queue of this host grows
+	pacer *pacer
+	state SessionState
+
+	// state negotiated with the host on the control channel
+	protocol Protocol
+	// idle holds the idle rate per report ID, ID 0 applies to all reports
+	idle     map[byte]byte
+	leds     byte
+	keys     keyState
+	buttons  MouseButton
+	repeater *repeater
+	gate     *gate
+}
+
+// NewConnection registers a connection to host. It has no interrupt channel
+// until Attach is called and is removed once its state is set to closed.
+func (ba *BluetoothKeyboardAdapter) NewConnection(host Host) *Connection {
+	c := &Connection{adapter: ba, host: host, protocol: ProtocolReport, idle: map[byte]byte{}}
+	ba.mux.Lock()
+	c.pacer = newPacer(ba.pacing)
+	ba.conns = append(ba.conns, c)
+	ba.mux.Unlock()
+	return c
+}
+
+// remove drops c from the connected hosts
+func (ba *BluetoothKeyboardAdapter) remove(c *Connection) {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+	for i, conn := range ba.conns {
+		if conn == c {
+			ba.conns = append(ba.conns[:i], ba.conns[i+1:]...)
+			// keep the counters, a reconnecting host must not reset them
+			stats := c.pacer.Stats()
+			stats.QueueDepth, stats.Throttled = 0, false
+			ba.removedPacing.add(stats)
+			return
+		}
+	}
+}
+
+// Host returns the host of the connection
+func (c *Connection) Host() Host {
+	return c.host
+}
+
+// Attach sets the interrupt channel reports are sent on. The connection
+// starts over in report protocol mode without repetition.
+func (c *Connection) Attach(bt transport.Transport) {
+	c.mu.Lock()
+	c.detach()
+	c.repeater = &repeater{Transport: &pacedTransport{Transport: bt, pacer: c.pacer, queue: outQueueOf(bt)}}
+	c.gate = newGate(c.repeater)
+	c.out = c.gate
+	c.queue = outQueueOf(bt)
+	c.protocol = ProtocolReport
+	c.idle = map[byte]byte{}
+	c.keys.reset()
+	c.buttons = 0
+	c.mu.Unlock()
+	c.updateRepeat()
+}
+
+// detach stops sending on the interrupt channel, c.mu must be held
+func (c *Connection) detach() {
+	if c.repeater != nil {
+		c.repeater.stop()
+	}
+	if c.gate != nil {
+		c.gate.release()
+	}
+}
+
+// State returns the lifecycle state of the session of the connection
+func (c *Connection) State() SessionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// SetState reports the state of the session, a closed connection stops
+// sending and is removed from the adapter
+func (c *Connection) SetState(state SessionState) {
+	c.mu.Lock()
+	c.state = state
+	if state == SessionClosed {
+		c.detach()
+	}
+	c.mu.Unlock()
+	if state == SessionClosed {
+		c.adapter.remove(c)
+	}
+}
+
+// ready reports whether keys can be typed, while suspended reports are held
+// back until the host wakes up
+func (c *Connection) ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out != nil && (c.state == SessionReady || c.state == SessionSuspended)
+}
+
+// typeKeys presses and releases the keys one after the other
+func (c *Connection) typeKeys(keys []string) error {
+	for _, key := range keys {
+		if !IsSupported(key) {
+			log.Debug("Skipping unsupported key ", key)
+			continue
+		}
+		log.Debugf("Sending key %s to %s", key, c.host.Address)
+		if err := c.press(key); err != nil {
+			log.Debug("Failure on Sending Keyboard State ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Status returns the status of the connection
+func (c *Connection) Status() HostStatus {
+	c.mu.Lock()
+	status := HostStatus{Host: c.host, State: c.state, Protocol: c.protocol, LEDs: ledsFromReport(c.leds), Pacing: c.pacer.Stats()}
+	q := c.queue
+	c.mu.Unlock()
+	if q != nil {
+		if depth, err := q.OutQueue(); err == nil {
+			status.QueueDepth = depth
+		}
+	}
+	return status
+}
@@ -0,0 +1,48 @@
+package hid
+
+import "encoding/binary"
+
+// consumerT maps media and application launch keys to their usages on the
+// Consumer page (0x0C), see the HID Usage Tables
+var consumerT = map[string]int{
+	"KEY_BRIGHTNESSUP":   0x6f,
+	"KEY_BRIGHTNESSDOWN": 0x70,
+	"KEY_PLAY":           0xb0,
+	"KEY_FASTFORWARD":    0xb3,
+	"KEY_REWIND":         0xb4,
+	"KEY_NEXTSONG":       0xb5,
+	"KEY_PREVIOUSSONG":   0xb6,
+	"KEY_STOPCD":         0xb7,
+	"KEY_EJECTCD":        0xb8,
+	"KEY_PLAYPAUSE":      0xcd,
+	"KEY_DICTATE":        0xcf,
+	"KEY_MUTE":           0xe2,
+	"KEY_VOLUMEUP":       0xe9,
+	"KEY_VOLUMEDOWN":     0xea,
+	"KEY_MAIL":           0x18a,
+	"KEY_CALC":           0x192,
+	"KEY_FILE":           0x194,
+	"KEY_WWW":            0x196,
+	"KEY_COFFEE":         0x19e,
+	"KEY_SCREENLOCK":     0x19e,
+	"KEY_FIND":           0x21f,
+	"KEY_SEARCH":         0x221,
+	"KEY_HOMEPAGE":       0x223,
+	"KEY_BACK":           0x224,
+	"KEY_FORWARD":        0x225,
+	"KEY_STOP":           0x226,
+	"KEY_REFRESH":        0x227,
+	"KEY_BOOKMARKS":      0x22a,
+	"KEY_SCROLLUP":       0x233,
+	"KEY_SCROLLDOWN":     0x234,
+	"KEY_EDIT":           0x23d,
+}
+
+// consumerReport returns the consumer control report with one usage held,
+// zero if none. Boot protocol has no consumer report.
+func consumerReport(usage uint16) []byte {
+	report := make([]byte, 1+consumerInputSize)
+	report[0] = ReportIDConsumer
+	binary.LittleEndian.PutUint16(report[1:], usage)
+	return report
+}
@@ -0,0 +1,34 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTypeKeyUsesConsumerReport(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	conn, rec := readyConnection(t, adapter, Host{})
+
+	if err := adapter.TypeKey("KEY_HOMEPAGE"); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec, "a1032302", "a1030000")
+
+	conn.KeyDown("KEY_VOLUMEUP")
+	report, err := conn.GetReport(ReportTypeInput, ReportIDConsumer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(report, []byte{ReportIDConsumer, 0xe9, 0}) {
+		t.Errorf("GET_REPORT should return the held consumer key, got %x", report)
+	}
+	conn.ReleaseAll()
+
+	// boot hosts know no consumer report
+	rec.frames = nil
+	conn.SetProtocol(ProtocolBoot)
+	if err := adapter.TypeKey("KEY_MUTE"); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec)
+}
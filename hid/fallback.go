@@ -0,0 +1,194 @@
+package hid
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+)
+
+// actions of a Keystroke, the default is to press and release the key
+const (
+	KeyActionDown = "down"
+	KeyActionUp   = "up"
+)
+
+// Fallback types characters the layout of the host has no key for, using an
+// input method of the host's operating system
+type Fallback interface {
+	Name() string
+	// Keystrokes returns the keystrokes typing r on a host with layout l,
+	// false if r cannot be typed this way
+	Keystrokes(r rune, l *Layout) ([]Keystroke, bool)
+}
+
+// strategies of a RuneReport besides the names of fallbacks
+const (
+	StrategyLayout    = "layout"
+	StrategyUntypable = "untypable"
+)
+
+// RuneReport tells how a character was typed
+type RuneReport struct {
+	Char     string `json:"char"`
+	Strategy string `json:"strategy"`
+}
+
+// TypeReport tells how each character of a text was typed
+type TypeReport struct {
+	Layout   string       `json:"layout"`
+	Fallback string       `json:"fallback,omitempty"`
+	Runes    []RuneReport `json:"runes"`
+}
+
+var (
+	fallbacksMu sync.Mutex
+	fallbacks   = map[string]Fallback{}
+)
+
+// RegisterFallback makes f available by its name
+func RegisterFallback(f Fallback) {
+	fallbacksMu.Lock()
+	defer fallbacksMu.Unlock()
+	fallbacks[f.Name()] = f
+}
+
+// FallbackByName returns the registered fallback called name
+func FallbackByName(name string) (Fallback, error) {
+	fallbacksMu.Lock()
+	defer fallbacksMu.Unlock()
+	f, ok := fallbacks[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := make([]string, 0, len(fallbacks))
+		for name := range fallbacks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown fallback %q, known fallbacks are %s", name, strings.Join(names, ", "))
+	}
+	return f, nil
+}
+
+// hexKeystrokes types the lower case hex digits of v with layout l
+func hexKeystrokes(v uint64, l *Layout) ([]Keystroke, bool) {
+	var keystrokes []Keystroke
+	for _, digit := range strconv.FormatUint(v, 16) {
+		ks, ok := l.Keystrokes(digit)
+		if !ok {
+			return nil, false
+		}
+		keystrokes = append(keystrokes, ks...)
+	}
+	return keystrokes, true
+}
+
+// macOSFallback uses the Unicode Hex Input source of macOS, which the host
+// has to have selected. Option is held while the UTF-16 code units are
+// typed as four hex digits each.
+type macOSFallback struct{}
+
+func (macOSFallback) Name() string { return "macos" }
+
+func (macOSFallback) Keystrokes(r rune, _ *Layout) ([]Keystroke, bool) {
+	// Unicode Hex Input has the keys of the US layout
+	us, err := LayoutByName("us")
+	if err != nil {
+		return nil, false
+	}
+	units := []rune{r}
+	if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+		units = []rune{r1, r2}
+	}
+	keystrokes := []Keystroke{{Key: "KEY_LEFTALT", Action: KeyActionDown}}
+	for _, u := range units {
+		for _, digit := range fmt.Sprintf("%04x", u) {
+			ks, _ := us.Keystrokes(digit)
+			keystrokes = append(keystrokes, ks...)
+		}
+	}
+	return append(keystrokes, Keystroke{Key: "KEY_LEFTALT", Action: KeyActionUp}), true
+}
+
+// linuxFallback uses Ctrl+Shift+U of GTK and IBus, followed by the code
+// point in hex and space
+type linuxFallback struct{}
+
+func (linuxFallback) Name() string { return "linux" }
+
+func (linuxFallback) Keystrokes(r rune, l *Layout) ([]Keystroke, bool) {
+	u, ok := l.Keystrokes('u')
+	if !ok || len(u) != 1 {
+		return nil, false
+	}
+	keystrokes := []Keystroke{{Key: u[0].Key, Modifiers: ModLeftCtrl | ModLeftShift}}
+	hex, ok := hexKeystrokes(uint64(r), l)
+	if !ok {
+		return nil, false
+	}
+	keystrokes = append(keystrokes, hex...)
+	return append(keystrokes, Keystroke{Key: "KEY_SPACE"}), true
+}
+
+// windowsFallback holds Alt and types the code on the numpad. Latin-1
+// characters use the decimal code with a leading zero, other characters of
+// the Basic Multilingual Plane use numpad plus and the hex code, which needs
+// EnableHexNumpad set in the registry of the host. The digits need Num Lock,
+// see usesNumpad.
+type windowsFallback struct{}
+
+func (windowsFallback) Name() string { return "windows" }
+
+func (windowsFallback) Keystrokes(r rune, l *Layout) ([]Keystroke, bool) {
+	keystrokes := []Keystroke{{Key: "KEY_LEFTALT", Action: KeyActionDown}}
+	switch {
+	case r >= 0xa0 && r <= 0xff:
+		for _, digit := range fmt.Sprintf("0%d", r) {
+			keystrokes = append(keystrokes, Keystroke{Key: "KEY_KP" + string(digit)})
+		}
+	case r > 0xff && r <= 0xffff && !utf16.IsSurrogate(r):
+		keystrokes = append(keystrokes, Keystroke{Key: "KEY_KPPLUS"})
+		for _, digit := range strconv.FormatUint(uint64(r), 16) {
+			if digit >= '0' && digit <= '9' {
+				keystrokes = append(keystrokes, Keystroke{Key: "KEY_KP" + string(digit)})
+				continue
+			}
+			ks, ok := l.Keystrokes(digit)
+			if !ok {
+				return nil, false
+			}
+			keystrokes = append(keystrokes, ks...)
+		}
+	default:
+		return nil, false
+	}
+	return append(keystrokes, Keystroke{Key: "KEY_LEFTALT", Action: KeyActionUp}), true
+}
+
+// usesNumpad reports whether keystrokes type numpad digits, which are
+// navigation keys while Num Lock is off
+func usesNumpad(keystrokes []Keystroke) bool {
+	for _, ks := range keystrokes {
+		if len(ks.Key) == len("KEY_KP0") && strings.HasPrefix(ks.Key, "KEY_KP") && unicode.IsDigit(rune(ks.Key[6])) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipFallback leaves characters out, the report tells which
+type skipFallback struct{}
+
+func (skipFallback) Name() string { return "skip" }
+
+func (skipFallback) Keystrokes(r rune, _ *Layout) ([]Keystroke, bool) {
+	return nil, true
+}
+
+func init() {
+	for _, f := range []Fallback{macOSFallback{}, linuxFallback{}, windowsFallback{}, skipFallback{}} {
+		RegisterFallback(f)
+	}
+}
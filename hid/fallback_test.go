@@ -0,0 +1,123 @@
+package hid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFallbacks(t *testing.T) {
+	us, _ := LayoutByName("us")
+	de, _ := LayoutByName("de")
+	cases := []struct {
+		fallback string
+		layout   *Layout
+		r        rune
+		want     []Keystroke
+	}{
+		{"macos", de, 'é', []Keystroke{
+			{Key: "KEY_LEFTALT", Action: KeyActionDown},
+			{Key: "KEY_0"}, {Key: "KEY_0"}, {Key: "KEY_E"}, {Key: "KEY_9"},
+			{Key: "KEY_LEFTALT", Action: KeyActionUp},
+		}},
+		{"macos", us, '😀', []Keystroke{
+			{Key: "KEY_LEFTALT", Action: KeyActionDown},
+			{Key: "KEY_D"}, {Key: "KEY_8"}, {Key: "KEY_3"}, {Key: "KEY_D"},
+			{Key: "KEY_D"}, {Key: "KEY_E"}, {Key: "KEY_0"}, {Key: "KEY_0"},
+			{Key: "KEY_LEFTALT", Action: KeyActionUp},
+		}},
+		{"linux", de, '√', []Keystroke{
+			{Key: "KEY_U", Modifiers: ModLeftCtrl | ModLeftShift},
+			{Key: "KEY_2"}, {Key: "KEY_2"}, {Key: "KEY_1"}, {Key: "KEY_A"},
+			{Key: "KEY_SPACE"},
+		}},
+		{"windows", us, 'é', []Keystroke{
+			{Key: "KEY_LEFTALT", Action: KeyActionDown},
+			{Key: "KEY_KP0"}, {Key: "KEY_KP2"}, {Key: "KEY_KP3"}, {Key: "KEY_KP3"},
+			{Key: "KEY_LEFTALT", Action: KeyActionUp},
+		}},
+		{"windows", us, '€', []Keystroke{
+			{Key: "KEY_LEFTALT", Action: KeyActionDown},
+			{Key: "KEY_KPPLUS"}, {Key: "KEY_KP2"}, {Key: "KEY_KP0"}, {Key: "KEY_A"}, {Key: "KEY_C"},
+			{Key: "KEY_LEFTALT", Action: KeyActionUp},
+		}},
+	}
+	for _, c := range cases {
+		fb, err := FallbackByName(c.fallback)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := fb.Keystrokes(c.r, c.layout)
+		if !ok {
+			t.Errorf("%s cannot type %q", c.fallback, c.r)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %q: expected %v, got %v", c.fallback, c.r, c.want, got)
+		}
+	}
+
+	windows, _ := FallbackByName("windows")
+	if _, ok := windows.Keystrokes('😀', us); ok {
+		t.Error("Alt codes cannot type characters outside the Basic Multilingual Plane")
+	}
+	if _, err := FallbackByName("beos"); err == nil {
+		t.Error("expected an error for an unknown fallback")
+	}
+}
+
+func TestTypeTextReport(t *testing.T) {
+	ba := NewBluetoothKeyboardAdapter()
+	conn, rec := readyConnection(t, ba, Host{})
+
+	if _, err := ba.TypeText("a€"); err == nil {
+		t.Error("expected an error without a fallback")
+	}
+	// nothing is typed if a character is untypable
+	assertFrames(t, rec)
+
+	report, err := ba.TypeTextWith("a€b", TypeOptions{Fallback: "skip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RuneReport{{"a", StrategyLayout}, {"€", "skip"}, {"b", StrategyLayout}}
+	if !reflect.DeepEqual(report.Runes, want) || report.Layout != "us" || report.Fallback != "skip" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	assertFrames(t, rec, "a1020000040000000000", "a1020000000000000000", "a1020000050000000000", "a1020000000000000000")
+
+	// Alt stays held over all digits
+	rec.frames = nil
+	windows, _ := FallbackByName("windows")
+	ba.SetFallback(windows)
+	if err := conn.SetReport(ReportTypeOutput, ReportIDKeyboard, []byte{LEDNumLock}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ba.TypeText("é"); err != nil {
+		t.Fatal(err)
+	}
+	altCode := []string{
+		"a1020400000000000000",
+		"a1020400620000000000",
+		"a1020400000000000000",
+		"a10204005a0000000000",
+		"a1020400000000000000",
+		"a10204005b0000000000",
+		"a1020400000000000000",
+		"a10204005b0000000000",
+		"a1020400000000000000",
+		"a1020000000000000000",
+	}
+	assertFrames(t, rec, altCode...)
+
+	// with Num Lock off the digits would move the cursor, Num Lock is
+	// turned on around the text
+	rec.frames = nil
+	if err := conn.SetReport(ReportTypeOutput, ReportIDKeyboard, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ba.TypeText("é"); err != nil {
+		t.Fatal(err)
+	}
+	numLock := []string{"a1020000530000000000", "a1020000000000000000"}
+	assertFrames(t, rec, append(append(numLock, altCode...), numLock...)...)
+}
@@ -0,0 +1,59 @@
+package hid
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recorder is a transport that counts the frames written to it
+type recorder struct {
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (r *recorder) Read(b []byte) (int, error) {
+	select {}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.frames)
+}
+
+// readyConnection connects a recorder to adapter as host, ready for keys
+func readyConnection(t *testing.T, adapter *BluetoothKeyboardAdapter, host Host) (*Connection, *recorder) {
+	t.Helper()
+	rec := &recorder{}
+	conn := adapter.NewConnection(host)
+	conn.Attach(rec)
+	conn.SetState(SessionReady)
+	return conn, rec
+}
+
+// assertFrames checks the frames written to rec, given in hex
+func assertFrames(t *testing.T, rec *recorder, want ...string) {
+	t.Helper()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.frames) != len(want) {
+		t.Fatalf("expected %d frames, got %x", len(want), rec.frames)
+	}
+	for i, w := range want {
+		if got := fmt.Sprintf("%x", rec.frames[i]); got != w {
+			t.Errorf("frame %d: expected %s, got %s", i, w, got)
+		}
+	}
+}
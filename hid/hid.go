@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -16,15 +17,6 @@ const (
 	DEVEVCBQUIT DeviceEventCtrl = iota
 )
 
-type DeviceError struct {
-	msg    string
-	method string
-}
-
-func (de *DeviceError) Error() string {
-	return fmt.Sprintf("DeviceError: '%s' by method: %s", de.msg, de.method)
-}
-
 /*
 BTKeyboard HID Report structure
 [
@@ -57,116 +49,232 @@ type KeyboardInput struct {
 }
 
 type KeyboardStatus struct {
-	IsReady bool
+	// IsReady is true while keys can be typed to at least one host, that is
+	// while a session is ready or suspended and holding reports back
+	IsReady bool `json:"isReady"`
+	// State is the state of the session of the host that connected last
+	State SessionState `json:"state"`
+	// QueueDepth is the number of bytes in the send queue of the connection
+	QueueDepth int `json:"queueDepth"`
+	// Throttled is true while reports to any host are delayed because its
+	// queue is growing
+	Throttled bool `json:"throttled"`
+	// Pacing adds up the pacing of all hosts, including the ones that
+	// disconnected unless the status is of a target
+	Pacing PacingStats `json:"pacing"`
+	// LEDs is the Caps, Num and Scroll Lock state of the host that connected last
+	LEDs LEDs `json:"leds"`
+	// Hosts lists the connected hosts in the order they connected
+	Hosts []HostStatus `json:"hosts"`
 }
 
 type Keyboard interface {
-	TypeText(keyInput string) error
+	// TypeText types text with the keyboard's layout and fallback and
+	// reports how each character is typed
+	TypeText(keyInput string) (TypeReport, error)
+	TypeTextWith(text string, opts TypeOptions) (TypeReport, error)
 	TypeKey(keyInput string) error
+	// KeyDown presses a key and holds it until KeyUp or ReleaseAll
+	KeyDown(keyInput string) error
+	KeyUp(keyInput string) error
+	// Press holds a key for the given time
+	Press(keyInput string, d time.Duration) error
+	ReleaseAll() error
+	// SendChord sends shortcuts like cmd+shift+3 or "ctrl+a, ctrl+c", see ParseChords
+	SendChord(expr string) error
 	Status() KeyboardStatus
+	// Target returns a keyboard that types to the host with the given
+	// address or BlueZ device path only, an empty target types to all hosts
+	Target(target string) (Keyboard, error)
 }
 
 type BluetoothKeyboardAdapter struct {
-	mux          sync.Mutex
-	btConnection *bluetooth.Bluetooth
-	status       KeyboardStatus
+	mux sync.Mutex
+	// pacing is used by the pacer of every connection
+	pacing Pacing
+	// removedPacing sums the pacing of the hosts that disconnected
+	removedPacing PacingStats
+	// conns holds a connection per host in the order they connected
+	conns []*Connection
+	// layout is the keyboard layout of the hosts, nil selects DefaultLayout
+	layout *Layout
+	// fallback types characters missing from layout, it may be nil
+	fallback Fallback
 }
 
 func NewBluetoothKeyboardAdapter() *BluetoothKeyboardAdapter {
-	return &BluetoothKeyboardAdapter{mux: sync.Mutex{}, status: KeyboardStatus{false}}
-}
-func (ba *BluetoothKeyboardAdapter) TypeText(keyinput string) error {
-	log.Infof("Start sending text '%s'", keyinput)
-	for _, c := range keyinput {
-		characterKey := strings.ToUpper(fmt.Sprintf("KEY_%c", c))
-		log.Infof("Sending key %s", characterKey)
-		SendKey(ba.btConnection, characterKey)
-	}
-	return nil
+	return &BluetoothKeyboardAdapter{mux: sync.Mutex{}, pacing: DefaultPacing}
 }
 
-func (ba *BluetoothKeyboardAdapter) TypeKey(keyinput string) error {
-	if !IsSupported(keyinput) {
-		return fmt.Errorf("Unsupported key: %s", keyinput)
-	}
-	log.Infof("Sending key %s", keyinput)
-	SendKey(ba.btConnection, keyinput)
+// targetKeyboard types to the hosts of an adapter matching target only
+type targetKeyboard struct {
+	adapter *BluetoothKeyboardAdapter
+	target  string
+}
 
-	return nil
+func (tk *targetKeyboard) TypeText(keyinput string) (TypeReport, error) {
+	return tk.adapter.typeText(tk.target, keyinput)
 }
 
-func (ba *BluetoothKeyboardAdapter) Status() KeyboardStatus {
-	return ba.status
+func (tk *targetKeyboard) TypeKey(keyinput string) error {
+	return tk.adapter.typeKey(tk.target, keyinput)
+}
+
+func (tk *targetKeyboard) Status() KeyboardStatus {
+	return tk.adapter.status(tk.target)
 }
-func (ba *BluetoothKeyboardAdapter) SetBtConnection(bt *bluetooth.Bluetooth) {
-	ba.mux.Lock()
-	defer ba.mux.Unlock()
-	ba.btConnection = bt
-	ba.status.IsReady = true
 
+func (tk *targetKeyboard) Target(target string) (Keyboard, error) {
+	return tk.adapter.Target(target)
 }
 
-func SendKey(btConnection *bluetooth.Bluetooth, characterKey string) {
-	state := make([]byte, 10)
-	state[0] = 0xA1
-	state[1] = 0x02
-	changeState(characterKey, state, true, false)
-	log.Debugf("%x", state)
-	if _, err := btConnection.Write(state); err != nil {
-		log.Debug("Failure on Sending Keyboard State")
-		return
+// Target returns a keyboard that types to the matching host only
+func (ba *BluetoothKeyboardAdapter) Target(target string) (Keyboard, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return ba, nil
 	}
-	state = make([]byte, 10)
-	state[0] = 0xA1
-	state[1] = 0x02
-
-	log.Debugf("%x", state)
-	if _, err := btConnection.Write(state); err != nil {
-		log.Debug("Failure on Sending Keyboard State")
-		return
+	if len(ba.connections(target)) == 0 {
+		return nil, ErrUnknownHost
 	}
-	log.Printf("Sending Keyboard State Done")
+	return &targetKeyboard{adapter: ba, target: target}, nil
 }
 
-func changeState(characterKeyName string, state []byte, keyDown bool, keyUp bool) error {
+// connections returns the connections matching target, all for an empty one
+func (ba *BluetoothKeyboardAdapter) connections(target string) []*Connection {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+	var conns []*Connection
+	for _, c := range ba.conns {
+		if target == "" || c.host.Matches(target) {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
 
-	key, mkey := Convert(characterKeyName)
-	keycode := uint16(key)
-	log.Debug(key)
-	log.Debug(mkey)
-	var err error = nil
-	switch mkey {
-	case MOD:
-		err = updateModifiers(keycode, state, true)
-	case FUNC:
-		updateStates(keycode, state, true, false)
+// each runs f for every ready host matching target, each host is sent to on
+// its own so a suspended or slow host does not hold back the others. It
+// returns ErrUnknownHost or ErrNotReady if there is no host to send to.
+func (ba *BluetoothKeyboardAdapter) each(target string, f func(c *Connection) error) error {
+	matching := ba.connections(target)
+	var conns []*Connection
+	for _, c := range matching {
+		if c.ready() {
+			conns = append(conns, c)
+		}
+	}
+	if len(conns) == 0 {
+		if target != "" && len(matching) == 0 {
+			return ErrUnknownHost
+		}
+		log.Warn("No host ready, dropping keys")
+		return ErrNotReady
+	}
+	errs := make([]error, len(conns))
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c *Connection) {
+			defer wg.Done()
+			errs[i] = f(c)
+		}(i, c)
 	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return err
+// typeKeys presses and releases keys one after the other on every ready host
+// matching target
+func (ba *BluetoothKeyboardAdapter) typeKeys(target string, keys []string) error {
+	return ba.each(target, func(c *Connection) error {
+		return c.typeKeys(keys)
+	})
 }
 
-func updateModifiers(keycode uint16, state []byte, keyDown bool) error {
-	if keycode > 8 { // length of 8 bits
-		return &DeviceError{msg: "bitpos(kev.Keycode) > 8", method: "updateModifiers()"}
+func (ba *BluetoothKeyboardAdapter) TypeText(keyinput string) (TypeReport, error) {
+	return ba.typeText("", keyinput)
+}
+
+func (ba *BluetoothKeyboardAdapter) typeText(target string, keyinput string) (TypeReport, error) {
+	return ba.typeTextWith(target, keyinput, TypeOptions{})
+}
+
+func (ba *BluetoothKeyboardAdapter) TypeKey(keyinput string) error {
+	return ba.typeKey("", keyinput)
+}
+
+func (ba *BluetoothKeyboardAdapter) typeKey(target string, keyinput string) error {
+	if !IsSupported(keyinput) {
+		return fmt.Errorf("Unsupported key: %s", keyinput)
 	}
+	log.Infof("Sending key %s", keyinput)
+	return ba.typeKeys(target, []string{keyinput})
+}
 
-	if keyDown {
-		state[2] |= byte(1 << keycode)
-		return nil
+func (ba *BluetoothKeyboardAdapter) Status() KeyboardStatus {
+	return ba.status("")
+}
+
+func (ba *BluetoothKeyboardAdapter) status(target string) KeyboardStatus {
+	status := KeyboardStatus{Hosts: []HostStatus{}}
+	// the last host to connect used to get all keys, its state stays at the
+	// top level for clients that know only one host. A host that is still
+	// connecting does not hide one that can be typed to.
+	var primary *HostStatus
+	if target == "" {
+		ba.mux.Lock()
+		status.Pacing = ba.removedPacing
+		ba.mux.Unlock()
+	}
+	for _, c := range ba.connections(target) {
+		status.Hosts = append(status.Hosts, c.Status())
 	}
-	state[2] &= byte(^(1 << keycode))
+	for i := range status.Hosts {
+		host := &status.Hosts[i]
+		live := host.State == SessionReady || host.State == SessionSuspended
+		if live || !status.IsReady {
+			primary = host
+		}
+		status.IsReady = status.IsReady || live
+		status.Pacing.add(host.Pacing)
+	}
+	status.Throttled = status.Pacing.Throttled
+	if primary != nil {
+		status.State = primary.State
+		status.LEDs = primary.LEDs
+		status.QueueDepth = primary.QueueDepth
+	}
+	return status
+}
 
-	return nil
+// SetPacing changes how reports are slowed down when the send queue of a host
+// grows, every host is paced on its own
+func (ba *BluetoothKeyboardAdapter) SetPacing(pacing Pacing) {
+	ba.mux.Lock()
+	ba.pacing = pacing
+	conns := append([]*Connection(nil), ba.conns...)
+	ba.mux.Unlock()
+	for _, c := range conns {
+		c.pacer.setPacing(pacing)
+	}
 }
 
-func updateStates(keycode uint16, state []byte, keyDown bool, keyUp bool) {
-	for i := 4; i < len(state); i++ {
-		switch {
-		case keyUp && byte(keycode) == state[i]:
-			state[i] = 0x00
-		case keyDown && state[i] == 0x00:
-			state[i] = byte(keycode)
-			return
+// SetBtConnection connects a host without a HIDP session, e.g. over a plain
+// socket. The connection replaces the previous one set this way and is ready
+// right away.
+func (ba *BluetoothKeyboardAdapter) SetBtConnection(bt transport.Transport) {
+	for _, c := range ba.connections("") {
+		if c.host == (Host{}) {
+			c.SetState(SessionClosed)
 		}
 	}
+	c := ba.NewConnection(Host{})
+	c.Attach(bt)
+	c.SetState(SessionReady)
 }
@@ -0,0 +1,66 @@
+package hid
+
+import "testing"
+
+func TestTypeKeyInBootProtocol(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	conn, rec := readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:FF"})
+	conn.SetProtocol(ProtocolBoot)
+
+	if err := adapter.TypeKey("KEY_A"); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec, "a1010000040000000000", "a1010000000000000000")
+}
+
+func TestTypeKeyToHosts(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	_, first := readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:01", Device: "/org/bluez/hci0/dev_AA_BB_CC_DD_EE_01"})
+	_, second := readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:02", Device: "/org/bluez/hci0/dev_AA_BB_CC_DD_EE_02"})
+
+	if err := adapter.TypeKey("KEY_A"); err != nil {
+		t.Fatal(err)
+	}
+	keyA := []string{"a1020000040000000000", "a1020000000000000000"}
+	assertFrames(t, first, keyA...)
+	assertFrames(t, second, keyA...)
+
+	keyboard, err := adapter.Target("/org/bluez/hci0/dev_AA_BB_CC_DD_EE_02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyboard.TypeKey("KEY_B"); err != nil {
+		t.Fatal(err)
+	}
+	// the key is typed to the targeted host only
+	assertFrames(t, first, keyA...)
+	assertFrames(t, second, append(keyA, "a1020000050000000000", "a1020000000000000000")...)
+	if hosts := keyboard.Status().Hosts; len(hosts) != 1 || hosts[0].Host.Address != "AA:BB:CC:DD:EE:02" {
+		t.Errorf("unexpected hosts in the status of the target: %+v", hosts)
+	}
+
+	if _, err := adapter.Target("aa:bb:cc:dd:ee:01"); err != nil {
+		t.Errorf("addresses should match case insensitive: %v", err)
+	}
+	if _, err := adapter.Target("AA:BB:CC:DD:EE:03"); err != ErrUnknownHost {
+		t.Errorf("expected ErrUnknownHost, got %v", err)
+	}
+}
+
+func TestTypeKeyWithoutReadyHost(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	if err := adapter.TypeKey("KEY_A"); err != ErrNotReady {
+		t.Errorf("expected ErrNotReady without hosts, got %v", err)
+	}
+
+	conn := adapter.NewConnection(Host{Address: "AA:BB:CC:DD:EE:01"})
+	conn.Attach(&recorder{})
+	conn.SetState(SessionConnected)
+	keyboard, err := adapter.Target("AA:BB:CC:DD:EE:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyboard.TypeKey("KEY_A"); err != ErrNotReady {
+		t.Errorf("expected ErrNotReady for a host that is still connecting, got %v", err)
+	}
+}
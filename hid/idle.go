@@ -0,0 +1,174 @@
+package hid
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// IdleUnit is the unit of the idle rate set by the host
+const IdleUnit = 4 * time.Millisecond
+
+// Idle returns the idle rate of report id in units of IdleUnit. Zero means
+// the report is only sent when it changes.
+func (c *Connection) Idle(id byte) byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idleOf(id)
+}
+
+// idleOf returns the idle rate of report id, c.mu must be held
+func (c *Connection) idleOf(id byte) byte {
+	if rate, ok := c.idle[id]; ok {
+		return rate
+	}
+	return c.idle[0]
+}
+
+// SetIdle sets the idle rate of report id, id 0 sets it for all reports
+func (c *Connection) SetIdle(id byte, rate byte) {
+	c.mu.Lock()
+	if id == 0 {
+		c.idle = map[byte]byte{0: rate}
+	} else {
+		c.idle[id] = rate
+	}
+	c.mu.Unlock()
+	c.updateRepeat()
+}
+
+// updateRepeat passes the idle rate of the keyboard report to the repeater
+func (c *Connection) updateRepeat() {
+	c.mu.Lock()
+	keyboard, _ := reportIDs(c.protocol)
+	period := time.Duration(c.idleOf(keyboard)) * IdleUnit
+	r := c.repeater
+	c.mu.Unlock()
+	if r != nil {
+		r.setKeyboard(keyboard, period)
+	}
+}
+
+// repeater resends the last keyboard report at the idle rate while keys are
+// held. Hosts use the repetition for key repeat and to detect a dead link.
+type repeater struct {
+	transport.Transport
+
+	mu         sync.Mutex
+	keyboardID byte
+	period     time.Duration
+	last       []byte
+	timer      *time.Timer
+	// paused is set while the host is suspended, the repetitions would
+	// bypass the gate
+	paused bool
+	// generation changes with every keyboard report, so a timer that fires
+	// late does not resend an outdated report
+	generation uint64
+}
+
+func isKeyboardFrame(b []byte, keyboardID byte) bool {
+	return len(b) == 2+keyboardInputSize && b[0] == 0xA1 && b[1] == keyboardID
+}
+
+// keysHeld reports whether a keyboard frame has a modifier or key down
+func keysHeld(b []byte) bool {
+	for _, v := range b[2:] {
+		if v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *repeater) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, err := r.Transport.Write(b)
+	if !isKeyboardFrame(b, r.keyboardID) {
+		return n, err
+	}
+	r.generation++
+	r.stopTimer()
+	r.last = nil
+	if err == nil && keysHeld(b) {
+		r.last = append([]byte(nil), b...)
+		// a write that got past the gate before the host suspended is sent
+		// once, the repetitions start again on resume
+		if !r.paused {
+			r.arm()
+		}
+	}
+	return n, err
+}
+
+func (r *repeater) setKeyboard(keyboardID byte, period time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keyboardID = keyboardID
+	r.period = period
+	r.stopTimer()
+	if !r.paused && r.last != nil && isKeyboardFrame(r.last, keyboardID) {
+		r.arm()
+	}
+}
+
+// arm starts the timer for the next repetition, r.mu must be held
+func (r *repeater) arm() {
+	if r.period <= 0 {
+		return
+	}
+	generation := r.generation
+	r.timer = time.AfterFunc(r.period, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.generation != generation || r.paused || r.last == nil {
+			return
+		}
+		if _, err := r.Transport.Write(r.last); err != nil {
+			log.Debug("Repeating keyboard report failed: ", err)
+			r.last = nil
+			return
+		}
+		r.arm()
+	})
+}
+
+// stopTimer cancels a pending repetition, r.mu must be held
+func (r *repeater) stopTimer() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// pause stops repeating but keeps the last report, setKeyboard repeats it
+// again after unpause
+func (r *repeater) pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = true
+	r.generation++
+	r.stopTimer()
+}
+
+func (r *repeater) unpause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = false
+}
+
+func (r *repeater) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	r.stopTimer()
+	r.last = nil
+}
+
+// Unwrap returns the repeated transport
+func (r *repeater) Unwrap() transport.Transport {
+	return r.Transport
+}
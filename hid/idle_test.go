@@ -0,0 +1,93 @@
+package hid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleRepeatsHeldKeys(t *testing.T) {
+	rec := &recorder{}
+	conn := NewBluetoothKeyboardAdapter().NewConnection(Host{})
+	conn.Attach(rec)
+	conn.SetIdle(0, 2)
+
+	pressed := []byte{0xa1, ReportIDKeyboard, 0, 0, 0x04, 0, 0, 0, 0, 0}
+	conn.out.Write(pressed)
+	time.Sleep(50 * time.Millisecond)
+	if n := rec.count(); n < 3 {
+		t.Fatalf("expected the held key to be repeated, got %d frames", n)
+	}
+
+	released := make([]byte, 10)
+	released[0], released[1] = 0xa1, ReportIDKeyboard
+	conn.out.Write(released)
+	n := rec.count()
+	time.Sleep(30 * time.Millisecond)
+	if rec.count() != n {
+		t.Errorf("reports were repeated after all keys were released")
+	}
+}
+
+func TestIdlePerReportID(t *testing.T) {
+	conn := NewBluetoothKeyboardAdapter().NewConnection(Host{})
+	conn.SetIdle(0, 10)
+	conn.SetIdle(ReportIDKeyboard, 0)
+	if rate := conn.Idle(ReportIDKeyboard); rate != 0 {
+		t.Errorf("expected keyboard idle rate 0, got %d", rate)
+	}
+	if rate := conn.Idle(ReportIDMouse); rate != 10 {
+		t.Errorf("expected mouse idle rate 10, got %d", rate)
+	}
+	conn.SetIdle(0, 20)
+	if rate := conn.Idle(ReportIDKeyboard); rate != 20 {
+		t.Errorf("expected idle rate for all reports to reset the keyboard rate, got %d", rate)
+	}
+}
+
+func TestIdleRepeatsAfterResume(t *testing.T) {
+	rec := &recorder{}
+	conn := NewBluetoothKeyboardAdapter().NewConnection(Host{})
+	conn.Attach(rec)
+	conn.SetIdle(0, 2)
+
+	pressed := []byte{0xa1, ReportIDKeyboard, 0, 0, 0x04, 0, 0, 0, 0, 0}
+	conn.out.Write(pressed)
+	conn.Suspend()
+	n := rec.count()
+	time.Sleep(30 * time.Millisecond)
+	if rec.count() != n {
+		t.Fatalf("reports were repeated to a suspended host")
+	}
+
+	// the host may change the idle rate before it exits suspend
+	conn.SetIdle(0, 1)
+	time.Sleep(30 * time.Millisecond)
+	if rec.count() != n {
+		t.Fatalf("reports were repeated to a suspended host after SET_IDLE")
+	}
+	conn.Resume()
+	time.Sleep(30 * time.Millisecond)
+	if got := rec.count() - n; got < 3 {
+		t.Errorf("expected the held key to be repeated after resume, got %d frames", got)
+	}
+}
+
+func TestIdleNotRepeatedAfterLateWrite(t *testing.T) {
+	rec := &recorder{}
+	conn := NewBluetoothKeyboardAdapter().NewConnection(Host{})
+	conn.Attach(rec)
+	conn.SetIdle(0, 1)
+	conn.Suspend()
+
+	// a write that passed the gate just before the host suspended
+	pressed := []byte{0xa1, ReportIDKeyboard, 0, 0, 0x04, 0, 0, 0, 0, 0}
+	conn.repeater.Write(pressed)
+	time.Sleep(30 * time.Millisecond)
+	assertFrames(t, rec, "a1020000040000000000")
+
+	conn.Resume()
+	time.Sleep(30 * time.Millisecond)
+	if n := rec.count(); n < 3 {
+		t.Errorf("expected the held key to be repeated after resume, got %d frames", n)
+	}
+}
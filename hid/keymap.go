@@ -116,10 +116,6 @@ var t = map[string]int{
 	"KEY_PAGEDOWN":         78,
 	"KEY_INSERT":           73,
 	"KEY_DELETE":           76,
-	"KEY_MUTE":             239,
-	"KEY_VOLUMEDOWN":       238,
-	"KEY_VOLUMEUP":         237,
-	"KEY_POWER":            102,
 	"KEY_KPEQUAL":          103,
 	"KEY_PAUSE":            72,
 	"KEY_KPCOMMA":          133,
@@ -129,7 +125,6 @@ var t = map[string]int{
 	"KEY_LEFTMETA":         227,
 	"KEY_RIGHTMETA":        231,
 	"KEY_COMPOSE":          101,
-	"KEY_STOP":             243,
 	"KEY_AGAIN":            121,
 	"KEY_PROPS":            118,
 	"KEY_UNDO":             122,
@@ -137,24 +132,8 @@ var t = map[string]int{
 	"KEY_COPY":             124,
 	"KEY_OPEN":             116,
 	"KEY_PASTE":            125,
-	"KEY_FIND":             244,
 	"KEY_CUT":              123,
 	"KEY_HELP":             117,
-	"KEY_CALC":             251,
-	"KEY_SLEEP":            248,
-	"KEY_WWW":              240,
-	"KEY_COFFEE":           249,
-	"KEY_BACK":             241,
-	"KEY_FORWARD":          242,
-	"KEY_EJECTCD":          236,
-	"KEY_NEXTSONG":         235,
-	"KEY_PLAYPAUSE":        232,
-	"KEY_PREVIOUSSONG":     234,
-	"KEY_STOPCD":           233,
-	"KEY_REFRESH":          250,
-	"KEY_EDIT":             247,
-	"KEY_SCROLLUP":         245,
-	"KEY_SCROLLDOWN":       246,
 	"KEY_F13":              104,
 	"KEY_F14":              105,
 	"KEY_F15":              106,
@@ -184,21 +163,39 @@ const (
 	UNKNOWN = iota
 	MOD
 	FUNC
+	// CONSUMER keys are sent in the consumer control report
+	CONSUMER
+	// SYSTEM keys are sent in the system control report
+	SYSTEM
+	// APPLE keys are sent in the report of Apple's vendor usages
+	APPLE
 )
 
 func SupportedKeys() []string {
 	i := 0
-	keys := make([]string, len(t))
+	keys := make([]string, len(t)+len(consumerT)+len(systemT)+len(appleT))
 	for k := range t {
 		keys[i] = k
 		i++
 	}
+	for k := range consumerT {
+		keys[i] = k
+		i++
+	}
+	for k := range systemT {
+		keys[i] = k
+		i++
+	}
+	for k := range appleT {
+		keys[i] = k
+		i++
+	}
 	return keys
 }
 
 func IsSupported(key string) bool {
-	_, ok := t[key]
-	return ok
+	_, kind := Convert(key)
+	return kind != UNKNOWN
 }
 
 func Convert(v string) (int, int) {
@@ -206,6 +203,12 @@ func Convert(v string) (int, int) {
 		return _v, MOD
 	} else if _v, ok := t[v]; ok {
 		return _v, FUNC
+	} else if _v, ok := consumerT[v]; ok {
+		return _v, CONSUMER
+	} else if _v, ok := systemT[v]; ok {
+		return _v, SYSTEM
+	} else if _v, ok := appleT[v]; ok {
+		return _v, APPLE
 	}
 
 	return -1, UNKNOWN
@@ -1,7 +1,10 @@
 package hid
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -22,3 +25,75 @@ func TestKeymapConvert(t *testing.T) {
 		t.Error("KEY_RIGHTMETA is not a function key: got ", mk, k)
 	}
 }
+
+// descriptorKeyRange returns the usages of the key array of the keyboard
+// report in sdp_record.xml
+func descriptorKeyRange(t *testing.T) (int, int) {
+	b, err := ioutil.ReadFile("../sdp_record.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`<text encoding="hex" value="([0-9a-f]+)"`).FindSubmatch(b)
+	if m == nil {
+		t.Fatal("no report descriptor in sdp_record.xml")
+	}
+	desc, err := hex.DecodeString(string(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page, reportID, logicalMin, logicalMax, usageMin, usageMax int
+	for i := 0; i < len(desc); {
+		prefix := desc[i]
+		size := int(prefix & 3)
+		if size == 3 {
+			size = 4
+		}
+		data := desc[i+1 : i+1+size]
+		i += 1 + size
+		var unsigned, signed int
+		for j := len(data) - 1; j >= 0; j-- {
+			unsigned = unsigned<<8 | int(data[j])
+		}
+		signed = unsigned
+		if size > 0 && data[size-1]&0x80 != 0 {
+			signed -= 1 << (8 * uint(size))
+		}
+		switch prefix &^ 3 {
+		case 0x04:
+			page = unsigned
+		case 0x14:
+			logicalMin = signed
+		case 0x24:
+			logicalMax = signed
+		case 0x84:
+			reportID = unsigned
+		case 0x18:
+			usageMin = unsigned
+		case 0x28:
+			usageMax = unsigned
+		case 0x80:
+			// an array input of keyboard usages
+			if page == 0x07 && reportID == ReportIDKeyboard && unsigned&2 == 0 {
+				if usageMax-usageMin != logicalMax-logicalMin {
+					t.Fatalf("logical range %d-%d does not match the usages %d-%d", logicalMin, logicalMax, usageMin, usageMax)
+				}
+				return usageMin, usageMax
+			}
+			usageMin, usageMax = 0, 0
+		}
+	}
+	t.Fatal("no key array in the keyboard report")
+	return 0, 0
+}
+
+func TestKeymapInDescriptor(t *testing.T) {
+	min, max := descriptorKeyRange(t)
+	if min != 0 || max != KeyboardUsageMax {
+		t.Fatalf("expected the keyboard report to cover usages 0-%#x, got %#x-%#x", KeyboardUsageMax, min, max)
+	}
+	for _, key := range SupportedKeys() {
+		if code, kind := Convert(key); kind == FUNC && code > max {
+			t.Errorf("%s has usage %#x outside of the keyboard report", key, code)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package hid
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyErrorRollOver fills all key slots while more keys are held than the
+// report has room for
+const KeyErrorRollOver = 0x01
+
+// keySlots is the number of keys a keyboard report can hold
+const keySlots = 6
+
+// keyState is the set of held keys behind the keyboard and consumer input
+// reports. Keys keep their slot while held, keys pressed while all slots are
+// taken wait for a free slot.
+type keyState struct {
+	modifiers byte
+	slots     [keySlots]byte
+	overflow  []byte
+	// consumer is the usage of the held consumer key, the report has room
+	// for one so a new key replaces it
+	consumer uint16
+	// system is the usage of the held system control key
+	system byte
+	// apple has a bit per held key of the Apple report
+	apple byte
+}
+
+// down presses key and reports whether the report changed
+func (k *keyState) down(key string) (bool, error) {
+	code, kind := Convert(key)
+	switch kind {
+	case MOD:
+		prev := k.modifiers
+		k.modifiers |= 1 << uint(code)
+		return prev != k.modifiers, nil
+	case FUNC:
+		if k.held(byte(code)) {
+			return false, nil
+		}
+		for i, slot := range k.slots {
+			if slot == 0 {
+				k.slots[i] = byte(code)
+				return true, nil
+			}
+		}
+		k.overflow = append(k.overflow, byte(code))
+		// the report changes to ErrorRollOver with the first key too many
+		return len(k.overflow) == 1, nil
+	case CONSUMER:
+		prev := k.consumer
+		k.consumer = uint16(code)
+		return prev != k.consumer, nil
+	case SYSTEM:
+		prev := k.system
+		k.system = byte(code)
+		return prev != k.system, nil
+	case APPLE:
+		prev := k.apple
+		k.apple |= 1 << uint(code)
+		return prev != k.apple, nil
+	}
+	return false, fmt.Errorf("Unsupported key: %s", key)
+}
+
+// up releases key and reports whether the report changed
+func (k *keyState) up(key string) (bool, error) {
+	code, kind := Convert(key)
+	switch kind {
+	case MOD:
+		prev := k.modifiers
+		k.modifiers &^= 1 << uint(code)
+		return prev != k.modifiers, nil
+	case FUNC:
+		for i, c := range k.overflow {
+			if c == byte(code) {
+				k.overflow = append(k.overflow[:i], k.overflow[i+1:]...)
+				return len(k.overflow) == 0, nil
+			}
+		}
+		for i, slot := range k.slots {
+			if slot != byte(code) {
+				continue
+			}
+			k.slots[i] = 0
+			if len(k.overflow) > 0 {
+				k.slots[i] = k.overflow[0]
+				k.overflow = k.overflow[1:]
+				// the report still shows ErrorRollOver unless that was the last waiting key
+				return len(k.overflow) == 0, nil
+			}
+			return true, nil
+		}
+		return false, nil
+	case CONSUMER:
+		if k.consumer != uint16(code) {
+			return false, nil
+		}
+		k.consumer = 0
+		return true, nil
+	case SYSTEM:
+		if k.system != byte(code) {
+			return false, nil
+		}
+		k.system = 0
+		return true, nil
+	case APPLE:
+		prev := k.apple
+		k.apple &^= 1 << uint(code)
+		return prev != k.apple, nil
+	}
+	return false, fmt.Errorf("Unsupported key: %s", key)
+}
+
+func (k *keyState) held(code byte) bool {
+	for _, slot := range k.slots {
+		if slot == code {
+			return true
+		}
+	}
+	for _, c := range k.overflow {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// reset releases all keys and reports whether any key was held
+func (k *keyState) reset() bool {
+	changed := !k.empty()
+	*k = keyState{}
+	return changed
+}
+
+func (k *keyState) empty() bool {
+	return k.modifiers == 0 && k.slots == [keySlots]byte{} && len(k.overflow) == 0 && k.consumer == 0 && k.system == 0 && k.apple == 0
+}
+
+// report returns the 8 byte keyboard input report without report ID
+func (k *keyState) report() []byte {
+	report := make([]byte, keyboardInputSize)
+	report[0] = k.modifiers
+	for i := range k.slots {
+		if len(k.overflow) > 0 {
+			report[2+i] = KeyErrorRollOver
+		} else {
+			report[2+i] = k.slots[i]
+		}
+	}
+	return report
+}
+
+// reports returns the input reports of protocol mode p with their report IDs.
+// The Apple report goes first since fn works like a modifier, hosts have to
+// see it held before the keys pressed with it.
+func (k *keyState) reports(p Protocol) [][]byte {
+	if p == ProtocolBoot {
+		return [][]byte{keyboardReport(p, k.report())}
+	}
+	return [][]byte{
+		appleReport(k.apple),
+		keyboardReport(p, k.report()),
+		consumerReport(k.consumer),
+		systemReport(k.system),
+	}
+}
+
+// update changes the held keys with f and sends the reports that changed.
+// Updates are sent one at a time so reports reach the host in order.
+func (c *Connection) update(f func(k *keyState) (bool, error)) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	before := c.keys.reports(c.protocol)
+	changed, err := f(&c.keys)
+	out, after := c.out, c.keys.reports(c.protocol)
+	c.mu.Unlock()
+	if err != nil || !changed || out == nil {
+		return err
+	}
+	for i, report := range after {
+		if bytes.Equal(report, before[i]) {
+			continue
+		}
+		log.Debugf("%x", report)
+		if _, err := out.Write(append([]byte{0xA1}, report...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyDown presses key and keeps it held until KeyUp or ReleaseAll
+func (c *Connection) KeyDown(key string) error {
+	return c.update(func(k *keyState) (bool, error) { return k.down(key) })
+}
+
+// KeyUp releases key
+func (c *Connection) KeyUp(key string) error {
+	return c.update(func(k *keyState) (bool, error) { return k.up(key) })
+}
+
+// ReleaseAll releases all held keys
+func (c *Connection) ReleaseAll() error {
+	return c.update(func(k *keyState) (bool, error) { return k.reset(), nil })
+}
+
+// press presses and releases key, keys that are held stay held
+func (c *Connection) press(key string) error {
+	if err := c.KeyDown(key); err != nil {
+		return err
+	}
+	return c.KeyUp(key)
+}
+
+// KeyDown presses key on every host until KeyUp or ReleaseAll
+func (ba *BluetoothKeyboardAdapter) KeyDown(key string) error {
+	return ba.keyDown("", key)
+}
+
+func (ba *BluetoothKeyboardAdapter) keyDown(target string, key string) error {
+	if !IsSupported(key) {
+		return fmt.Errorf("Unsupported key: %s", key)
+	}
+	return ba.each(target, func(c *Connection) error { return c.KeyDown(key) })
+}
+
+// KeyUp releases key on every host
+func (ba *BluetoothKeyboardAdapter) KeyUp(key string) error {
+	return ba.keyUp("", key)
+}
+
+func (ba *BluetoothKeyboardAdapter) keyUp(target string, key string) error {
+	if !IsSupported(key) {
+		return fmt.Errorf("Unsupported key: %s", key)
+	}
+	return ba.each(target, func(c *Connection) error { return c.KeyUp(key) })
+}
+
+// Press holds key for d, long enough presses make the host repeat the key
+func (ba *BluetoothKeyboardAdapter) Press(key string, d time.Duration) error {
+	return ba.pressFor("", key, d)
+}
+
+func (ba *BluetoothKeyboardAdapter) pressFor(target string, key string, d time.Duration) error {
+	if err := ba.keyDown(target, key); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return ba.keyUp(target, key)
+}
+
+// ReleaseAll releases all held keys on every host
+func (ba *BluetoothKeyboardAdapter) ReleaseAll() error {
+	return ba.releaseAll("")
+}
+
+func (ba *BluetoothKeyboardAdapter) releaseAll(target string) error {
+	return ba.each(target, func(c *Connection) error { return c.ReleaseAll() })
+}
+
+func (tk *targetKeyboard) KeyDown(key string) error {
+	return tk.adapter.keyDown(tk.target, key)
+}
+
+func (tk *targetKeyboard) KeyUp(key string) error {
+	return tk.adapter.keyUp(tk.target, key)
+}
+
+func (tk *targetKeyboard) Press(key string, d time.Duration) error {
+	return tk.adapter.pressFor(tk.target, key, d)
+}
+
+func (tk *targetKeyboard) ReleaseAll() error {
+	return tk.adapter.releaseAll(tk.target)
+}
@@ -0,0 +1,73 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyStateRollOver(t *testing.T) {
+	var k keyState
+	keys := []string{"KEY_A", "KEY_B", "KEY_C", "KEY_D", "KEY_E", "KEY_F"}
+	for _, key := range keys {
+		if changed, err := k.down(key); err != nil || !changed {
+			t.Fatalf("pressing %s: changed %v, err %v", key, changed, err)
+		}
+	}
+	if report := k.report(); !bytes.Equal(report, []byte{0, 0, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("unexpected report %x", report)
+	}
+
+	k.down("KEY_G")
+	rollOver := []byte{0, 0, 1, 1, 1, 1, 1, 1}
+	if report := k.report(); !bytes.Equal(report, rollOver) {
+		t.Errorf("expected ErrorRollOver with seven keys, got %x", report)
+	}
+
+	// the waiting key takes the slot of the released one
+	if changed, _ := k.up("KEY_B"); !changed {
+		t.Error("releasing a key should end the roll over")
+	}
+	if report := k.report(); !bytes.Equal(report, []byte{0, 0, 4, 10, 6, 7, 8, 9}) {
+		t.Errorf("unexpected report %x", report)
+	}
+
+	k.down("KEY_LEFTSHIFT")
+	if changed, _ := k.down("KEY_A"); changed {
+		t.Error("pressing a held key should not change the report")
+	}
+	if report := k.report(); report[0] != 1<<1 {
+		t.Errorf("expected left shift to be held, got %x", report)
+	}
+	if !k.reset() || !k.empty() {
+		t.Error("expected all keys to be released")
+	}
+	if _, err := k.down("KEY_NOPE"); err == nil {
+		t.Error("expected an error for an unsupported key")
+	}
+}
+
+func TestKeyDownHoldsModifiers(t *testing.T) {
+	conn, rec := readyConnection(t, NewBluetoothKeyboardAdapter(), Host{})
+
+	conn.KeyDown("KEY_LEFTSHIFT")
+	conn.typeKeys([]string{"KEY_A", "KEY_B"})
+	conn.KeyUp("KEY_LEFTSHIFT")
+
+	assertFrames(t, rec,
+		"a1020200000000000000",
+		"a1020200040000000000",
+		"a1020200000000000000",
+		"a1020200050000000000",
+		"a1020200000000000000",
+		"a1020000000000000000",
+	)
+
+	conn.KeyDown("KEY_Z")
+	report, err := conn.GetReport(ReportTypeInput, ReportIDKeyboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(report, []byte{ReportIDKeyboard, 0, 0, 29, 0, 0, 0, 0, 0}) {
+		t.Errorf("GET_REPORT should return the held keys, got %x", report)
+	}
+}
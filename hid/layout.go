@@ -0,0 +1,322 @@
+package hid
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// modifier bits of the keyboard report
+const (
+	ModLeftCtrl   = 1 << 0
+	ModLeftShift  = 1 << 1
+	ModLeftAlt    = 1 << 2
+	ModLeftMeta   = 1 << 3
+	ModRightCtrl  = 1 << 4
+	ModRightShift = 1 << 5
+	ModRightAlt   = 1 << 6
+	ModRightMeta  = 1 << 7
+
+	// ModAltGr selects the third level of European layouts
+	ModAltGr = ModRightAlt
+)
+
+// Keystroke is a key pressed while the modifiers are held. With an Action
+// the key is only pressed or released, e.g. to hold a modifier over the
+// following keystrokes.
+type Keystroke struct {
+	Key       string `json:"key"`
+	Modifiers byte   `json:"modifiers,omitempty"`
+	Action    string `json:"action,omitempty"`
+}
+
+// Layout maps characters to the keystrokes that type them with a host
+// keyboard layout. Characters behind dead keys take two keystrokes.
+type Layout struct {
+	Name  string
+	runes map[rune][]Keystroke
+}
+
+// DefaultLayout is used when no layout is selected
+const DefaultLayout = "us"
+
+var (
+	layoutsMu sync.Mutex
+	layouts   = map[string]*Layout{}
+	// builtinLayouts holds the names of the layouts of layouts.go
+	builtinLayouts = map[string]bool{}
+)
+
+// RegisterLayout makes l available by its name, a layout of the same name
+// is replaced
+func RegisterLayout(l *Layout) {
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+	layouts[strings.ToLower(l.Name)] = l
+}
+
+// LayoutByName returns the registered layout called name, case insensitive
+func LayoutByName(name string) (*Layout, error) {
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+	l, ok := layouts[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown layout %q, known layouts are %s", name, strings.Join(layoutNames(), ", "))
+	}
+	return l, nil
+}
+
+// LayoutNames returns the names of all registered layouts
+func LayoutNames() []string {
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+	return layoutNames()
+}
+
+func layoutNames() []string {
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewLayout creates an empty layout, characters are added with Add
+func NewLayout(name string) *Layout {
+	l := &Layout{Name: name, runes: map[rune][]Keystroke{}}
+	// these keys are the same in all layouts
+	l.Add(' ', Keystroke{Key: "KEY_SPACE"})
+	l.Add('\n', Keystroke{Key: "KEY_ENTER"})
+	l.Add('\t', Keystroke{Key: "KEY_TAB"})
+	return l
+}
+
+// Add maps r to keystrokes, an existing mapping is kept since the first
+// way to type a character is usually the common one
+func (l *Layout) Add(r rune, keystrokes ...Keystroke) {
+	if _, ok := l.runes[r]; ok {
+		return
+	}
+	l.runes[r] = keystrokes
+}
+
+// Keystrokes returns the keystrokes typing r
+func (l *Layout) Keystrokes(r rune) ([]Keystroke, bool) {
+	ks, ok := l.runes[r]
+	return ks, ok
+}
+
+// Runes returns the characters the layout can type
+func (l *Layout) Runes() []rune {
+	runes := make([]rune, 0, len(l.runes))
+	for r := range l.runes {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	return runes
+}
+
+// UntypableError lists the characters of a text a layout cannot type
+type UntypableError struct {
+	Layout string
+	Chars  []rune
+}
+
+func (e *UntypableError) Error() string {
+	quoted := make([]string, len(e.Chars))
+	for i, r := range e.Chars {
+		quoted[i] = fmt.Sprintf("%q", r)
+	}
+	return fmt.Sprintf("layout %s cannot type %s", e.Layout, strings.Join(quoted, ", "))
+}
+
+// Compile returns the keystrokes typing text. If characters are missing from
+// the layout it returns an *UntypableError listing each of them once.
+func (l *Layout) Compile(text string) ([]Keystroke, error) {
+	keystrokes, _, err := l.compile(text, nil)
+	return keystrokes, err
+}
+
+// compile returns the keystrokes typing text and how each character is
+// typed. Characters missing from the layout are typed with fb if it is not
+// nil.
+func (l *Layout) compile(text string, fb Fallback) ([]Keystroke, TypeReport, error) {
+	report := TypeReport{Layout: l.Name, Runes: []RuneReport{}}
+	if fb != nil {
+		report.Fallback = fb.Name()
+	}
+	var keystrokes []Keystroke
+	var missing []rune
+	seen := map[rune]bool{}
+	for i, r := range text {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(text[i:]); size == 1 {
+				return nil, report, fmt.Errorf("text is not valid UTF-8")
+			}
+		}
+		if r == '\r' {
+			// \r\n is typed as a single Enter
+			if strings.HasPrefix(text[i+1:], "\n") {
+				continue
+			}
+			r = '\n'
+		}
+		strategy := StrategyLayout
+		ks, ok := l.runes[r]
+		if !ok && fb != nil {
+			strategy = fb.Name()
+			ks, ok = fb.Keystrokes(r, l)
+		}
+		if !ok {
+			strategy = StrategyUntypable
+			if !seen[r] {
+				missing = append(missing, r)
+				seen[r] = true
+			}
+		}
+		keystrokes = append(keystrokes, ks...)
+		report.Runes = append(report.Runes, RuneReport{Char: string(r), Strategy: strategy})
+	}
+	if len(missing) > 0 {
+		return nil, report, &UntypableError{Layout: l.Name, Chars: missing}
+	}
+	return keystrokes, report, nil
+}
+
+// TypeOptions changes how TypeTextWith types a text
+type TypeOptions struct {
+	// Layout is the name of the host's keyboard layout, the keyboard's
+	// layout is used if it is empty
+	Layout string
+	// Fallback is the name of the fallback typing characters missing from
+	// the layout, the keyboard's fallback is used if it is empty
+	Fallback string
+}
+
+// SetLayout selects the layout of the hosts TypeText types to
+func (ba *BluetoothKeyboardAdapter) SetLayout(l *Layout) {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+	ba.layout = l
+}
+
+// Layout returns the layout TypeText uses
+func (ba *BluetoothKeyboardAdapter) Layout() *Layout {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+	if ba.layout == nil {
+		l, _ := LayoutByName(DefaultLayout)
+		return l
+	}
+	return ba.layout
+}
+
+// SetFallback selects how TypeText types characters missing from the layout,
+// it depends on the operating system of the hosts. With nil nothing is typed
+// if characters are missing.
+func (ba *BluetoothKeyboardAdapter) SetFallback(fb Fallback) {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+	ba.fallback = fb
+}
+
+// Fallback returns the fallback TypeText uses, it may be nil
+func (ba *BluetoothKeyboardAdapter) Fallback() Fallback {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+	return ba.fallback
+}
+
+// TypeTextWith types text using opts and reports how each character is
+// typed. Nothing is typed if characters cannot be typed.
+func (ba *BluetoothKeyboardAdapter) TypeTextWith(text string, opts TypeOptions) (TypeReport, error) {
+	return ba.typeTextWith("", text, opts)
+}
+
+func (ba *BluetoothKeyboardAdapter) typeTextWith(target string, text string, opts TypeOptions) (TypeReport, error) {
+	layout := ba.Layout()
+	if opts.Layout != "" {
+		var err error
+		if layout, err = LayoutByName(opts.Layout); err != nil {
+			return TypeReport{}, err
+		}
+	}
+	fallback := ba.Fallback()
+	if opts.Fallback != "" {
+		var err error
+		if fallback, err = FallbackByName(opts.Fallback); err != nil {
+			return TypeReport{}, err
+		}
+	}
+	keystrokes, report, err := layout.compile(text, fallback)
+	if err != nil {
+		return report, err
+	}
+	log.Infof("Start sending text '%s' with layout %s", text, layout.Name)
+	numpad := usesNumpad(keystrokes)
+	return report, ba.each(target, func(c *Connection) error {
+		// numpad digits need Num Lock, it is turned on for the text and off
+		// again afterwards
+		numLock := Keystroke{Key: "KEY_NUMLOCK"}
+		toggle := numpad && !c.LEDs().NumLock
+		if toggle {
+			if err := c.sendKeystroke(numLock); err != nil {
+				return err
+			}
+		}
+		for _, ks := range keystrokes {
+			if err := c.sendKeystroke(ks); err != nil {
+				return err
+			}
+		}
+		if toggle {
+			return c.sendKeystroke(numLock)
+		}
+		return nil
+	})
+}
+
+func (tk *targetKeyboard) TypeTextWith(text string, opts TypeOptions) (TypeReport, error) {
+	return tk.adapter.typeTextWith(tk.target, text, opts)
+}
+
+// sendKeystroke presses the key of ks with its modifiers added to the held
+// ones, and releases it restoring the held modifiers
+func (c *Connection) sendKeystroke(ks Keystroke) error {
+	switch ks.Action {
+	case KeyActionDown:
+		return c.update(func(k *keyState) (bool, error) {
+			held := k.modifiers
+			k.modifiers |= ks.Modifiers
+			changed, err := k.down(ks.Key)
+			return changed || held != k.modifiers, err
+		})
+	case KeyActionUp:
+		return c.update(func(k *keyState) (bool, error) {
+			return k.up(ks.Key)
+		})
+	}
+	var held byte
+	err := c.update(func(k *keyState) (bool, error) {
+		held = k.modifiers
+		k.modifiers |= ks.Modifiers
+		if _, err := k.down(ks.Key); err != nil {
+			k.modifiers = held
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.update(func(k *keyState) (bool, error) {
+		_, err := k.up(ks.Key)
+		k.modifiers = held
+		return true, err
+	})
+}
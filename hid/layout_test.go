@@ -0,0 +1,89 @@
+package hid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLayoutCompile(t *testing.T) {
+	cases := []struct {
+		layout string
+		text   string
+		want   []Keystroke
+	}{
+		{"us", "Hi!\n", []Keystroke{{Key: "KEY_H", Modifiers: ModLeftShift}, {Key: "KEY_I"}, {Key: "KEY_1", Modifiers: ModLeftShift}, {Key: "KEY_ENTER"}}},
+		{"uk", "\"£#", []Keystroke{{Key: "KEY_2", Modifiers: ModLeftShift}, {Key: "KEY_3", Modifiers: ModLeftShift}, {Key: "KEY_BACKSLASH"}}},
+		{"de", "z@é", []Keystroke{{Key: "KEY_Y"}, {Key: "KEY_Q", Modifiers: ModAltGr}, {Key: "KEY_EQUAL"}, {Key: "KEY_E"}}},
+		{"fr", "a1ê^", []Keystroke{{Key: "KEY_Q"}, {Key: "KEY_1", Modifiers: ModLeftShift}, {Key: "KEY_LEFTBRACE"}, {Key: "KEY_E"}, {Key: "KEY_9", Modifiers: ModAltGr}}},
+		{"es", "ñ¿ü", []Keystroke{{Key: "KEY_SEMICOLON"}, {Key: "KEY_EQUAL", Modifiers: ModLeftShift}, {Key: "KEY_APOSTROPHE", Modifiers: ModLeftShift}, {Key: "KEY_U"}}},
+		{"it", "{@", []Keystroke{{Key: "KEY_LEFTBRACE", Modifiers: ModLeftShift | ModAltGr}, {Key: "KEY_SEMICOLON", Modifiers: ModAltGr}}},
+		{"nordic", "å~", []Keystroke{{Key: "KEY_LEFTBRACE"}, {Key: "KEY_RIGHTBRACE", Modifiers: ModAltGr}, {Key: "KEY_SPACE"}}},
+		{"jis", "@\\¥", []Keystroke{{Key: "KEY_LEFTBRACE"}, {Key: "KEY_RO"}, {Key: "KEY_YEN"}}},
+		{"us", "a\r\nb", []Keystroke{{Key: "KEY_A"}, {Key: "KEY_ENTER"}, {Key: "KEY_B"}}},
+	}
+	for _, c := range cases {
+		l, err := LayoutByName(c.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Compile(c.text)
+		if err != nil {
+			t.Errorf("%s %q: %v", c.layout, c.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %q: expected %v, got %v", c.layout, c.text, c.want, got)
+		}
+	}
+}
+
+func TestLayoutsTypeASCII(t *testing.T) {
+	var ascii []rune
+	for r := rune(0x20); r < 0x7f; r++ {
+		ascii = append(ascii, r)
+	}
+	for _, name := range LayoutNames() {
+		l, _ := LayoutByName(name)
+		_, err := l.Compile(string(ascii))
+		if name == "it" {
+			// the Italian layout has no key for these
+			if e, ok := err.(*UntypableError); !ok || !reflect.DeepEqual(e.Chars, []rune{'`', '~'}) {
+				t.Errorf("it: expected ` and ~ to be untypable, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestLayoutListsUntypableChars(t *testing.T) {
+	l, _ := LayoutByName("us")
+	_, err := l.Compile("grüße aus köln")
+	e, ok := err.(*UntypableError)
+	if !ok {
+		t.Fatalf("expected an UntypableError, got %v", err)
+	}
+	if !reflect.DeepEqual(e.Chars, []rune{'ü', 'ß', 'ö'}) {
+		t.Errorf("unexpected untypable characters %q", e.Chars)
+	}
+	if _, err := LayoutByName("klingon"); err == nil {
+		t.Error("expected an error for an unknown layout")
+	}
+}
+
+func TestLayoutsStayInKeyboardReport(t *testing.T) {
+	for _, name := range LayoutNames() {
+		l, _ := LayoutByName(name)
+		for _, r := range l.Runes() {
+			ks, _ := l.Keystrokes(r)
+			for _, k := range ks {
+				code, kind := Convert(k.Key)
+				if kind != MOD && (kind != FUNC || code > KeyboardUsageMax) {
+					t.Errorf("%s: %q is typed with %s, which the keyboard report cannot send", name, r, k.Key)
+				}
+			}
+		}
+	}
+}
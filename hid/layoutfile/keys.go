@@ -0,0 +1,75 @@
+// Package layoutfile compiles XKB symbols files and Windows KLC files into
+// keyboard layouts for the hid package.
+package layoutfile
+
+import (
+	"sort"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+)
+
+// levelModifiers are the modifiers selecting the shift levels of a key, the
+// third level is AltGr on the layouts we care about
+var levelModifiers = [4]byte{0, hid.ModLeftShift, hid.ModAltGr, hid.ModLeftShift | hid.ModAltGr}
+
+// level is a character or dead key typed by a key at a shift level
+type level struct {
+	r    rune
+	dead bool
+}
+
+// keyLevels are the levels of a key, a zero rune marks an empty level
+type keyLevels [4]level
+
+// keyOrder is the order keys are added to a layout in, so the same file
+// always gives the same layout
+var keyOrder = []string{
+	"KEY_GRAVE", "KEY_1", "KEY_2", "KEY_3", "KEY_4", "KEY_5", "KEY_6", "KEY_7", "KEY_8", "KEY_9", "KEY_0", "KEY_MINUS", "KEY_EQUAL", "KEY_YEN",
+	"KEY_Q", "KEY_W", "KEY_E", "KEY_R", "KEY_T", "KEY_Y", "KEY_U", "KEY_I", "KEY_O", "KEY_P", "KEY_LEFTBRACE", "KEY_RIGHTBRACE",
+	"KEY_A", "KEY_S", "KEY_D", "KEY_F", "KEY_G", "KEY_H", "KEY_J", "KEY_K", "KEY_L", "KEY_SEMICOLON", "KEY_APOSTROPHE", "KEY_BACKSLASH",
+	"KEY_102ND", "KEY_Z", "KEY_X", "KEY_C", "KEY_V", "KEY_B", "KEY_N", "KEY_M", "KEY_COMMA", "KEY_DOT", "KEY_SLASH", "KEY_RO",
+	"KEY_SPACE",
+}
+
+// deadCompositions are compositions given by the file, keyed by accent. XKB
+// files have none, the layout then composes the common accented letters.
+type deadCompositions map[rune][][2]rune
+
+// build adds the characters of keys to a new layout, levels without dead
+// keys first so the direct way to type a character wins
+func build(name string, keys map[string]keyLevels, compositions deadCompositions) *hid.Layout {
+	l := hid.NewLayout(name)
+	dead := map[rune]hid.Keystroke{}
+	for i, mods := range levelModifiers {
+		for _, key := range keyOrder {
+			lv := keys[key][i]
+			switch {
+			case lv.r == 0:
+			case lv.dead:
+				if _, ok := dead[lv.r]; !ok {
+					dead[lv.r] = hid.Keystroke{Key: key, Modifiers: mods}
+				}
+			default:
+				l.Add(lv.r, hid.Keystroke{Key: key, Modifiers: mods})
+			}
+		}
+	}
+	accents := make([]rune, 0, len(dead))
+	for accent := range dead {
+		accents = append(accents, accent)
+	}
+	sort.Slice(accents, func(i, j int) bool { return accents[i] < accents[j] })
+	for _, accent := range accents {
+		pairs, ok := compositions[accent]
+		if !ok {
+			l.AddDeadKey(accent, dead[accent])
+			continue
+		}
+		for _, pair := range pairs {
+			if base, ok := l.Keystrokes(pair[0]); ok && len(base) == 1 {
+				l.Add(pair[1], dead[accent], base[0])
+			}
+		}
+	}
+	return l
+}
@@ -0,0 +1,118 @@
+package layoutfile
+
+import (
+	"strconv"
+	"strings"
+)
+
+// latin1Keysyms are the names of the keysyms 0x20 to 0x7e and 0xa0 to 0xff,
+// their values are the Latin-1 code points
+var latin1Keysyms = [][]string{
+	{"space", "exclam", "quotedbl", "numbersign", "dollar", "percent", "ampersand", "apostrophe",
+		"parenleft", "parenright", "asterisk", "plus", "comma", "minus", "period", "slash",
+		"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "colon", "semicolon", "less", "equal", "greater", "question",
+		"at", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O",
+		"P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z", "bracketleft", "backslash", "bracketright", "asciicircum", "underscore",
+		"grave", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o",
+		"p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z", "braceleft", "bar", "braceright", "asciitilde"},
+	{"nobreakspace", "exclamdown", "cent", "sterling", "currency", "yen", "brokenbar", "section",
+		"diaeresis", "copyright", "ordfeminine", "guillemotleft", "notsign", "hyphen", "registered", "macron",
+		"degree", "plusminus", "twosuperior", "threesuperior", "acute", "mu", "paragraph", "periodcentered",
+		"cedilla", "onesuperior", "masculine", "guillemotright", "onequarter", "onehalf", "threequarters", "questiondown",
+		"Agrave", "Aacute", "Acircumflex", "Atilde", "Adiaeresis", "Aring", "AE", "Ccedilla",
+		"Egrave", "Eacute", "Ecircumflex", "Ediaeresis", "Igrave", "Iacute", "Icircumflex", "Idiaeresis",
+		"ETH", "Ntilde", "Ograve", "Oacute", "Ocircumflex", "Otilde", "Odiaeresis", "multiply",
+		"Oslash", "Ugrave", "Uacute", "Ucircumflex", "Udiaeresis", "Yacute", "THORN", "ssharp",
+		"agrave", "aacute", "acircumflex", "atilde", "adiaeresis", "aring", "ae", "ccedilla",
+		"egrave", "eacute", "ecircumflex", "ediaeresis", "igrave", "iacute", "icircumflex", "idiaeresis",
+		"eth", "ntilde", "ograve", "oacute", "ocircumflex", "otilde", "odiaeresis", "division",
+		"oslash", "ugrave", "uacute", "ucircumflex", "udiaeresis", "yacute", "thorn", "ydiaeresis"},
+}
+
+// keysyms maps keysym names outside Latin-1 to characters
+var keysyms = map[string]rune{
+	"guillemetleft":  '«',
+	"guillemetright": '»',
+	"ordmasculine":   'º',
+	"Ooblique":       'Ø',
+	"ooblique":       'ø',
+	"EuroSign":       '€',
+	"Aogonek":        'Ą',
+	"aogonek":        'ą',
+	"Cacute":         'Ć',
+	"cacute":         'ć',
+	"Ccaron":         'Č',
+	"ccaron":         'č',
+	"Ecaron":         'Ě',
+	"ecaron":         'ě',
+	"Eogonek":        'Ę',
+	"eogonek":        'ę',
+	"Lstroke":        'Ł',
+	"lstroke":        'ł',
+	"Nacute":         'Ń',
+	"nacute":         'ń',
+	"Rcaron":         'Ř',
+	"rcaron":         'ř',
+	"Sacute":         'Ś',
+	"sacute":         'ś',
+	"Scaron":         'Š',
+	"scaron":         'š',
+	"Uring":          'Ů',
+	"uring":          'ů',
+	"Zacute":         'Ź',
+	"zacute":         'ź',
+	"Zabovedot":      'Ż',
+	"zabovedot":      'ż',
+	"Zcaron":         'Ž',
+	"zcaron":         'ž',
+	"OE":             'Œ',
+	"oe":             'œ',
+	"ellipsis":       '…',
+	"endash":         '–',
+	"emdash":         '—',
+}
+
+// deadKeysyms maps dead keysyms to the accent they put on the next letter
+var deadKeysyms = map[string]rune{
+	"dead_grave":      '`',
+	"dead_acute":      '´',
+	"dead_circumflex": '^',
+	"dead_tilde":      '~',
+	"dead_diaeresis":  '¨',
+	"dead_cedilla":    '¸',
+	"dead_caron":      'ˇ',
+	"dead_abovering":  '˚',
+}
+
+func init() {
+	for i, r := range []rune{0x20, 0xa0} {
+		for j, name := range latin1Keysyms[i] {
+			keysyms[name] = r + rune(j)
+		}
+	}
+}
+
+// keysymLevel returns the level typed by the keysym name, false for keysyms
+// that type no character like NoSymbol or ISO_Level3_Shift
+func keysymLevel(name string) (level, bool) {
+	if r, ok := keysyms[name]; ok {
+		return level{r: r}, true
+	}
+	if r, ok := deadKeysyms[name]; ok {
+		return level{r: r, dead: true}, true
+	}
+	// Unicode keysyms are written U20AC or 0x10020ac
+	hex := ""
+	switch {
+	case len(name) > 1 && name[0] == 'U':
+		hex = name[1:]
+	case strings.HasPrefix(name, "0x100"):
+		hex = name[5:]
+	}
+	if hex != "" {
+		if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return level{r: rune(v)}, true
+		}
+	}
+	return level{}, false
+}
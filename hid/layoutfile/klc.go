@@ -0,0 +1,206 @@
+package layoutfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+)
+
+// klcScanCodes maps the scan codes of KLC files to our key names
+var klcScanCodes = map[uint64]string{
+	0x29: "KEY_GRAVE", 0x02: "KEY_1", 0x03: "KEY_2", 0x04: "KEY_3", 0x05: "KEY_4", 0x06: "KEY_5",
+	0x07: "KEY_6", 0x08: "KEY_7", 0x09: "KEY_8", 0x0a: "KEY_9", 0x0b: "KEY_0", 0x0c: "KEY_MINUS",
+	0x0d: "KEY_EQUAL", 0x7d: "KEY_YEN",
+	0x10: "KEY_Q", 0x11: "KEY_W", 0x12: "KEY_E", 0x13: "KEY_R", 0x14: "KEY_T", 0x15: "KEY_Y",
+	0x16: "KEY_U", 0x17: "KEY_I", 0x18: "KEY_O", 0x19: "KEY_P", 0x1a: "KEY_LEFTBRACE", 0x1b: "KEY_RIGHTBRACE",
+	0x1e: "KEY_A", 0x1f: "KEY_S", 0x20: "KEY_D", 0x21: "KEY_F", 0x22: "KEY_G", 0x23: "KEY_H",
+	0x24: "KEY_J", 0x25: "KEY_K", 0x26: "KEY_L", 0x27: "KEY_SEMICOLON", 0x28: "KEY_APOSTROPHE", 0x2b: "KEY_BACKSLASH",
+	0x56: "KEY_102ND", 0x2c: "KEY_Z", 0x2d: "KEY_X", 0x2e: "KEY_C", 0x2f: "KEY_V", 0x30: "KEY_B",
+	0x31: "KEY_N", 0x32: "KEY_M", 0x33: "KEY_COMMA", 0x34: "KEY_DOT", 0x35: "KEY_SLASH", 0x73: "KEY_RO",
+	0x39: "KEY_SPACE",
+}
+
+// klcLevels maps KLC shift states to levels, Ctrl+Alt is AltGr. Other
+// states type control characters and are ignored.
+var klcLevels = map[int]int{0: 0, 1: 1, 6: 2, 7: 3}
+
+// klcSections are the keywords starting a section of a KLC file
+var klcSections = map[string]bool{
+	"KBD": true, "COPYRIGHT": true, "COMPANY": true, "LOCALENAME": true, "LOCALEID": true, "VERSION": true,
+	"ATTRIBUTES": true, "SHIFTSTATE": true, "LAYOUT": true, "DEADKEY": true, "LIGATURE": true, "KEYNAME": true,
+	"KEYNAME_EXT": true, "KEYNAME_DEAD": true, "DESCRIPTIONS": true, "LANGUAGENAMES": true, "ENDKBD": true,
+}
+
+// LoadKLC compiles a KLC file of the Microsoft Keyboard Layout Creator. The
+// layout is named after the KBD line of the file if name is empty.
+func LoadKLC(file string, name string) (*hid.Layout, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKLC(src, name)
+}
+
+// ParseKLC compiles the KLC file src, which is usually UTF-16
+func ParseKLC(src []byte, name string) (*hid.Layout, error) {
+	text, err := decodeKLC(src)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]keyLevels{}
+	compositions := deadCompositions{}
+	var states []int
+	section := ""
+	var accent rune
+	for n, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if klcSections[fields[0]] {
+			// section lines may end with a ; comment, on other lines ; is
+			// the character of a key
+			for i, field := range fields {
+				if strings.HasPrefix(field, ";") {
+					fields = fields[:i]
+					break
+				}
+			}
+			section = fields[0]
+			switch section {
+			case "KBD":
+				if name == "" && len(fields) > 1 {
+					name = fields[1]
+				}
+			case "DEADKEY":
+				if len(fields) < 2 {
+					return nil, fmt.Errorf("line %d: DEADKEY without accent", n+1)
+				}
+				r, err := klcChar(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n+1, err)
+				}
+				accent = r
+				compositions[accent] = nil
+			}
+			continue
+		}
+		switch section {
+		case "SHIFTSTATE":
+			state, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid shift state %q", n+1, fields[0])
+			}
+			states = append(states, state)
+		case "LAYOUT":
+			if err := klcLayoutLine(fields, states, keys); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+		case "DEADKEY":
+			if len(fields) < 2 {
+				continue
+			}
+			base, err := klcChar(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			composed, err := klcChar(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			compositions[accent] = append(compositions[accent], [2]rune{base, composed})
+		}
+	}
+	if len(states) == 0 || len(keys) == 0 {
+		return nil, fmt.Errorf("no SHIFTSTATE or LAYOUT section found")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("layout has no name")
+	}
+	return build(name, keys, compositions), nil
+}
+
+// klcLayoutLine parses a line like "10 Q 1 q Q -1 @ -1", the scan code, the
+// virtual key, the Caps Lock behaviour and a character per shift state
+func klcLayoutLine(fields []string, states []int, keys map[string]keyLevels) error {
+	if fields[0] == "-1" {
+		// the characters of an SGCap key with Caps Lock on, text is typed
+		// without Caps Lock
+		return nil
+	}
+	sc, err := strconv.ParseUint(fields[0], 16, 8)
+	if err != nil {
+		return fmt.Errorf("invalid scan code %q", fields[0])
+	}
+	key, ok := klcScanCodes[sc]
+	if !ok || len(fields) < 3 {
+		return nil
+	}
+	var levels keyLevels
+	for i, value := range fields[3:] {
+		if i >= len(states) {
+			break
+		}
+		lv, ok := klcLevels[states[i]]
+		if !ok || value == "-1" || value == "%%" {
+			continue
+		}
+		dead := strings.HasSuffix(value, "@")
+		r, err := klcChar(strings.TrimSuffix(value, "@"))
+		if err != nil {
+			return err
+		}
+		levels[lv] = level{r: r, dead: dead}
+	}
+	keys[key] = levels
+	return nil
+}
+
+// klcChar parses a character, either the character itself or its code
+// point as four hex digits
+func klcChar(s string) (rune, error) {
+	if utf8.RuneCountInString(s) == 1 {
+		r, _ := utf8.DecodeRuneInString(s)
+		return r, nil
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid character %q", s)
+	}
+	return rune(v), nil
+}
+
+// decodeKLC returns the text of a KLC file, MSKLC saves UTF-16LE with a BOM
+func decodeKLC(src []byte) (string, error) {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(src, []byte{0xff, 0xfe}):
+		order, src = binary.LittleEndian, src[2:]
+	case bytes.HasPrefix(src, []byte{0xfe, 0xff}):
+		order, src = binary.BigEndian, src[2:]
+	default:
+		text := strings.TrimPrefix(string(src), "\ufeff")
+		if !utf8.ValidString(text) {
+			return "", fmt.Errorf("KLC file is neither UTF-16 nor UTF-8")
+		}
+		return strings.Replace(text, "\r", "", -1), nil
+	}
+	if len(src)%2 != 0 {
+		return "", fmt.Errorf("KLC file has an odd number of UTF-16 bytes")
+	}
+	units := make([]uint16, len(src)/2)
+	for i := range units {
+		units[i] = order.Uint16(src[2*i:])
+	}
+	return strings.Replace(string(utf16.Decode(units)), "\r", "", -1), nil
+}
@@ -0,0 +1,280 @@
+package layoutfile
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+)
+
+// a cut down version of the German symbols file
+const xkbGerman = `
+default partial alphanumeric_keys
+xkb_symbols "basic" {
+    include "latin(type4)"
+    name[Group1]="German";
+
+    key <AE02>	{ [         2,   quotedbl,  twosuperior,    oneeighth ] };
+    key <AE12>	{ [ dead_acute, dead_grave, dead_cedilla,  dead_ogonek ] };
+    key <AD01>	{ [         q,          Q,           at,  Greek_OMEGA ] };
+    key <AD03>	{ [         e,          E,     EuroSign,     EuroSign ] };
+    key <AD06>	{ [         z,          Z,    leftarrow,          yen ] };
+    key <AC10>	{ type[Group1]="FOUR_LEVEL_SEMIALPHABETIC", [odiaeresis, Odiaeresis, dead_doubleacute, dead_doubleacute] };
+    key <TLDE>	{ [ dead_circumflex, degree, U2032, U2033 ] };
+    key <LSGT>	{ symbols[Group1]= [ less, greater, bar, dead_belowmacron ] };
+
+    include "kpdl(comma)"
+};
+
+partial alphanumeric_keys
+xkb_symbols "nodeadkeys" {
+    include "de(basic)"
+    key <AE12>	{ [     acute,      grave,      cedilla,       ogonek ] };
+};
+`
+
+// the parts of latin(type4) the German layout uses
+const xkbLatin = `
+partial alphanumeric_keys
+xkb_symbols "type4" {
+    key <AC01>	{ [         a,          A ] };
+    key <AD01>	{ [         q,          Q,   backslash ] };
+    key <AD07>	{ [         u,          U ] };
+    key <SPCE>	{ [     space,      space ] };
+};
+`
+
+func TestXKB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xkb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{"de": xkbGerman, "latin": xkbLatin} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := XKB{Dir: dir}.Load(filepath.Join(dir, "de"), "", "de-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, l, map[rune][]hid.Keystroke{
+		'"': {{Key: "KEY_2", Modifiers: hid.ModLeftShift}},
+		'@': {{Key: "KEY_Q", Modifiers: hid.ModAltGr}},
+		'€': {{Key: "KEY_E", Modifiers: hid.ModAltGr}},
+		'y': nil,
+		'z': {{Key: "KEY_Y"}},
+		'Ö': {{Key: "KEY_SEMICOLON", Modifiers: hid.ModLeftShift}},
+		'|': {{Key: "KEY_102ND", Modifiers: hid.ModAltGr}},
+		'é': {{Key: "KEY_EQUAL"}, {Key: "KEY_E"}},
+		'Û': {{Key: "KEY_GRAVE"}, {Key: "KEY_U", Modifiers: hid.ModLeftShift}},
+		'ç': nil,
+		'á': {{Key: "KEY_EQUAL"}, {Key: "KEY_A"}},
+		// the included key is replaced by the one of the German file
+		'\\': nil,
+	})
+
+	l, err = XKB{Dir: dir}.Load(filepath.Join(dir, "de"), "nodeadkeys", "de-nodeadkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, l, map[rune][]hid.Keystroke{
+		'´': {{Key: "KEY_EQUAL"}},
+		'a': {{Key: "KEY_A"}},
+		'é': nil,
+	})
+
+	if _, err := (XKB{}).Parse([]byte(xkbGerman), "neo", "neo"); err == nil {
+		t.Error("expected an error for a missing variant")
+	}
+}
+
+func TestKLC(t *testing.T) {
+	const klc = "KBD\tkbdtest\t\"Test Layout\"\r\n" +
+		"\r\n" +
+		"SHIFTSTATE\r\n\r\n0\t//Column 4\r\n1\t//Column 5 : Shft\r\n2\t//Column 6 :       Ctrl\r\n6\t//Column 7 :       Ctrl Alt\r\n\r\n" +
+		"LAYOUT\t\t;an extra '@' at the end is a dead key\r\n\r\n" +
+		"//SC\tVK_\t\tCap\t0\t1\t2\t6\r\n" +
+		"02\t1\t\t0\t1\t0021\t-1\t-1\t// DIGIT ONE, EXCLAMATION MARK\r\n" +
+		"10\tQ\t\t1\tq\tQ\t-1\t0040\t// LATIN SMALL LETTER Q, LATIN CAPITAL LETTER Q, <none>, COMMERCIAL AT\r\n" +
+		"12\tE\t\t1\te\tE\t-1\t20ac\r\n" +
+		"1e\tA\t\t1\ta\tA\t-1\t-1\r\n" +
+		"27\tOEM_1\t\t0\t;\t:\t-1\t-1\t// SEMICOLON, COLON\r\n" +
+		"0c\tOEM_4\t\tSGCap\t00df\t003f\t-1\t005c\t// LATIN SMALL LETTER SHARP S, QUESTION MARK, <none>, REVERSE SOLIDUS\r\n" +
+		"-1\t-1\t\t0\t1e9e\t003f\t\t\t// LATIN CAPITAL LETTER SHARP S, QUESTION MARK\r\n" +
+		"0d\tOEM_PLUS\t0\t00b4@\t0060@\t-1\t-1\r\n" +
+		"39\tSPACE\t\t0\t0020\t0020\t0020\t-1\r\n" +
+		"\r\n" +
+		"DEADKEY\t00b4\r\n\r\n0061\t00e1\t// a -> á\r\n0065\t00e9\t// e -> é\r\n0020\t00b4\r\n\r\n" +
+		"DEADKEY\t0060\r\n\r\n0061\t00e0\r\n0020\t0060\r\n\r\n" +
+		"KEYNAME\r\n\r\n01\tEsc\r\n\r\nENDKBD\r\n"
+
+	units := utf16.Encode([]rune(klc))
+	src := []byte{0xff, 0xfe}
+	for _, u := range units {
+		src = append(src, 0, 0)
+		binary.LittleEndian.PutUint16(src[len(src)-2:], u)
+	}
+	l, err := ParseKLC(src, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "kbdtest" {
+		t.Errorf("expected the name of the KBD line, got %s", l.Name)
+	}
+	expect(t, l, map[rune][]hid.Keystroke{
+		'!':  {{Key: "KEY_1", Modifiers: hid.ModLeftShift}},
+		'@':  {{Key: "KEY_Q", Modifiers: hid.ModAltGr}},
+		'€':  {{Key: "KEY_E", Modifiers: hid.ModAltGr}},
+		'ß':  {{Key: "KEY_MINUS"}},
+		';':  {{Key: "KEY_SEMICOLON"}},
+		':':  {{Key: "KEY_SEMICOLON", Modifiers: hid.ModLeftShift}},
+		'\\': {{Key: "KEY_MINUS", Modifiers: hid.ModAltGr}},
+		// the Caps Lock row of the SGCap key is not used
+		'ẞ': nil,
+		'é': {{Key: "KEY_EQUAL"}, {Key: "KEY_E"}},
+		'à': {{Key: "KEY_EQUAL", Modifiers: hid.ModLeftShift}, {Key: "KEY_A"}},
+		'`': {{Key: "KEY_EQUAL", Modifiers: hid.ModLeftShift}, {Key: "KEY_SPACE"}},
+		// only compositions of the file are used
+		'è': nil,
+	})
+}
+
+func TestLayoutJSON(t *testing.T) {
+	l, err := ParseKLC([]byte("KBD x\nSHIFTSTATE\n0\n1\nLAYOUT\n10 Q 1 q Q\n0d OEM 0 00b4@ -1\n"), "roundtrip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "layouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := l.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "roundtrip.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := hid.LoadLayouts(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"roundtrip"}) {
+		t.Fatalf("unexpected layouts %v", names)
+	}
+	loaded, err := hid.LayoutByName("roundtrip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Runes(), l.Runes()) {
+		t.Errorf("expected %q, got %q", l.Runes(), loaded.Runes())
+	}
+	expect(t, loaded, map[rune][]hid.Keystroke{
+		'Q': {{Key: "KEY_Q", Modifiers: hid.ModLeftShift}},
+		'´': {{Key: "KEY_EQUAL"}, {Key: "KEY_SPACE"}},
+	})
+}
+
+func TestLoadLayoutsRejects(t *testing.T) {
+	cases := []struct {
+		name   string
+		layout string
+	}{
+		{"unknown action", `{"version":1,"name":"bad","chars":{"a":[{"key":"KEY_A","action":"tap"}]}}`},
+		{"modifiers on release", `{"version":1,"name":"bad","chars":{"a":[{"key":"KEY_A","modifiers":2,"action":"up"}]}}`},
+		{"modifiers beyond a byte", `{"version":1,"name":"bad","chars":{"a":[{"key":"KEY_A","modifiers":256}]}}`},
+		{"built-in name", `{"version":1,"name":"US","chars":{"a":[{"key":"KEY_A"}]}}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "layouts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(c.layout), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if names, err := hid.LoadLayouts(dir, false); err == nil {
+				t.Errorf("expected an error, loaded %v", names)
+			}
+		})
+	}
+}
+
+func TestLoadLayoutsOverride(t *testing.T) {
+	us, err := hid.LayoutByName("us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hid.RegisterLayout(us)
+	dir, err := ioutil.TempDir("", "layouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "us.json"), []byte(`{"version":1,"name":"us","chars":{"a":[{"key":"KEY_Q"}]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hid.LoadLayouts(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := hid.LayoutByName("us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, loaded, map[rune][]hid.Keystroke{'a': {{Key: "KEY_Q"}}})
+}
+
+// expect checks the keystrokes of characters, nil means the layout must not
+// type the character
+func expect(t *testing.T, l *hid.Layout, chars map[rune][]hid.Keystroke) {
+	t.Helper()
+	for r, want := range chars {
+		got, ok := l.Keystrokes(r)
+		if want == nil {
+			if ok {
+				t.Errorf("%s: %q should not be typeable, got %v", l.Name, r, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %q: expected %v, got %v", l.Name, r, want, got)
+		}
+	}
+}
+
+func TestLatin1Keysyms(t *testing.T) {
+	if len(latin1Keysyms[0]) != 0x7f-0x20 || len(latin1Keysyms[1]) != 0x100-0xa0 {
+		t.Fatalf("keysym tables have %d and %d entries", len(latin1Keysyms[0]), len(latin1Keysyms[1]))
+	}
+	for name, r := range map[string]rune{"asciitilde": '~', "odiaeresis": 'ö', "ssharp": 'ß', "U20AC": '€'} {
+		if lv, ok := keysymLevel(name); !ok || lv.r != r {
+			t.Errorf("%s: expected %q, got %q", name, r, lv.r)
+		}
+	}
+}
+
+func TestKeysInKeyboardReport(t *testing.T) {
+	var keys []string
+	for _, key := range xkbKeys {
+		keys = append(keys, key)
+	}
+	for _, key := range klcScanCodes {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if code, kind := hid.Convert(key); kind != hid.FUNC || code > hid.KeyboardUsageMax {
+			t.Errorf("%s is not a key of the keyboard report", key)
+		}
+	}
+}
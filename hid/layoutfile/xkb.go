@@ -0,0 +1,196 @@
+package layoutfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultXKBDir is where XKB symbols files are installed on most systems
+const DefaultXKBDir = "/usr/share/X11/xkb/symbols"
+
+// maxIncludeDepth stops include loops
+const maxIncludeDepth = 10
+
+// xkbKeys maps XKB key names to our key names
+var xkbKeys = map[string]string{
+	"TLDE": "KEY_GRAVE", "AE01": "KEY_1", "AE02": "KEY_2", "AE03": "KEY_3", "AE04": "KEY_4", "AE05": "KEY_5",
+	"AE06": "KEY_6", "AE07": "KEY_7", "AE08": "KEY_8", "AE09": "KEY_9", "AE10": "KEY_0", "AE11": "KEY_MINUS",
+	"AE12": "KEY_EQUAL", "AE13": "KEY_YEN",
+	"AD01": "KEY_Q", "AD02": "KEY_W", "AD03": "KEY_E", "AD04": "KEY_R", "AD05": "KEY_T", "AD06": "KEY_Y",
+	"AD07": "KEY_U", "AD08": "KEY_I", "AD09": "KEY_O", "AD10": "KEY_P", "AD11": "KEY_LEFTBRACE", "AD12": "KEY_RIGHTBRACE",
+	"AC01": "KEY_A", "AC02": "KEY_S", "AC03": "KEY_D", "AC04": "KEY_F", "AC05": "KEY_G", "AC06": "KEY_H",
+	"AC07": "KEY_J", "AC08": "KEY_K", "AC09": "KEY_L", "AC10": "KEY_SEMICOLON", "AC11": "KEY_APOSTROPHE",
+	"AC12": "KEY_BACKSLASH", "BKSL": "KEY_BACKSLASH",
+	"LSGT": "KEY_102ND", "AB01": "KEY_Z", "AB02": "KEY_X", "AB03": "KEY_C", "AB04": "KEY_V", "AB05": "KEY_B",
+	"AB06": "KEY_N", "AB07": "KEY_M", "AB08": "KEY_COMMA", "AB09": "KEY_DOT", "AB10": "KEY_SLASH", "AB11": "KEY_RO",
+	"SPCE": "KEY_SPACE",
+}
+
+var (
+	xkbComment = regexp.MustCompile(`//[^\n]*`)
+	xkbBlock   = regexp.MustCompile(`((?:\w+\s+)*)xkb_symbols\s+"([^"]*)"\s*\{`)
+	xkbInclude = regexp.MustCompile(`^include\s+"([^"]*)"`)
+	xkbKey     = regexp.MustCompile(`(?s)^(?:\w+\s+)?key\s*<(\w+)>\s*\{(.*)\}$`)
+	xkbType    = regexp.MustCompile(`type(?:\[\w+\])?\s*=\s*"[^"]*"\s*,?`)
+	xkbSymbols = regexp.MustCompile(`symbols\[\w+\]\s*=\s*\[([^\]]*)\]`)
+	xkbLevels  = regexp.MustCompile(`\[([^\]]*)\]`)
+	xkbSpec    = regexp.MustCompile(`^([\w\-/]+)(?:\(([\w\-]+)\))?$`)
+)
+
+// XKB compiles layouts from XKB symbols files
+type XKB struct {
+	// Dir is the symbols directory includes are looked up in. Includes are
+	// skipped when it is empty, so the file has to define all keys.
+	Dir string
+}
+
+// Load compiles variant of the symbols file, the default variant is used
+// if variant is empty. The layout is called name.
+func (x XKB) Load(file string, variant string, name string) (*hid.Layout, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return x.Parse(src, variant, name)
+}
+
+// Parse compiles variant of the symbols file src
+func (x XKB) Parse(src []byte, variant string, name string) (*hid.Layout, error) {
+	keys := map[string]keyLevels{}
+	if err := x.symbols(string(src), variant, keys, 0); err != nil {
+		return nil, err
+	}
+	return build(name, keys, nil), nil
+}
+
+// symbols adds the keys of variant in src to keys, included files first
+func (x XKB) symbols(src string, variant string, keys map[string]keyLevels, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("includes nested deeper than %d", maxIncludeDepth)
+	}
+	body, err := xkbVariant(xkbComment.ReplaceAllString(src, ""), variant)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range strings.Split(body, ";") {
+		stmt = strings.TrimSpace(stmt)
+		// includes end without a semicolon, so they start the next statement
+		for {
+			m := xkbInclude.FindStringSubmatch(stmt)
+			if m == nil {
+				break
+			}
+			if err := x.include(m[1], keys, depth); err != nil {
+				return err
+			}
+			stmt = strings.TrimSpace(stmt[len(m[0]):])
+		}
+		m := xkbKey.FindStringSubmatch(stmt)
+		if m == nil {
+			continue
+		}
+		key, ok := xkbKeys[m[1]]
+		if !ok {
+			continue
+		}
+		if levels, ok := xkbKeyLevels(m[2]); ok {
+			keys[key] = levels
+		}
+	}
+	return nil
+}
+
+// xkbVariant returns the body of the xkb_symbols block called variant or of
+// the default block
+func xkbVariant(src string, variant string) (string, error) {
+	matches := xkbBlock.FindAllStringSubmatchIndex(src, -1)
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no xkb_symbols found")
+	}
+	chosen := -1
+	for i, m := range matches {
+		modifiers, name := src[m[2]:m[3]], src[m[4]:m[5]]
+		if (variant == "" && strings.Contains(modifiers, "default")) || (variant != "" && name == variant) {
+			chosen = i
+			break
+		}
+	}
+	if chosen < 0 {
+		if variant != "" {
+			return "", fmt.Errorf("variant %q not found", variant)
+		}
+		chosen = 0
+	}
+	start := matches[chosen][1]
+	depth := 1
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return src[start:i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("xkb_symbols %q is not closed", src[matches[chosen][4]:matches[chosen][5]])
+}
+
+// include adds the keys of an include like "latin(type4)+level3(ralt_switch)"
+func (x XKB) include(spec string, keys map[string]keyLevels, depth int) error {
+	if x.Dir == "" {
+		log.Debug("No XKB directory, skipping include ", spec)
+		return nil
+	}
+	for _, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == '+' || r == '|' }) {
+		m := xkbSpec.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return fmt.Errorf("invalid include %q", part)
+		}
+		src, err := ioutil.ReadFile(filepath.Join(x.Dir, m[1]))
+		if err != nil {
+			log.Debug("Skipping include ", part, ": ", err)
+			continue
+		}
+		if err := x.symbols(string(src), m[2], keys, depth+1); err != nil {
+			return fmt.Errorf("include %s: %v", part, err)
+		}
+	}
+	return nil
+}
+
+// xkbKeyLevels parses the first group of a key definition like
+// { type[Group1]="FOUR_LEVEL", [ q, Q, at, Greek_OMEGA ] }
+func xkbKeyLevels(def string) (keyLevels, bool) {
+	def = xkbType.ReplaceAllString(def, "")
+	list := ""
+	if m := xkbSymbols.FindStringSubmatch(def); m != nil {
+		list = m[1]
+	} else if m := xkbLevels.FindStringSubmatch(def); m != nil {
+		list = m[1]
+	} else {
+		return keyLevels{}, false
+	}
+	var levels keyLevels
+	for i, sym := range strings.Split(list, ",") {
+		if i >= len(levels) {
+			break
+		}
+		if lv, ok := keysymLevel(strings.TrimSpace(sym)); ok {
+			levels[i] = lv
+		}
+	}
+	// alphabetic keys may leave out the upper case letter
+	if r := levels[0].r; levels[1].r == 0 && !levels[0].dead && unicode.IsLower(r) {
+		levels[1] = level{r: unicode.ToUpper(r)}
+	}
+	return levels, true
+}
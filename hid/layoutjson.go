@@ -0,0 +1,97 @@
+package hid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// LayoutFormatVersion is the version of the JSON layout format
+const LayoutFormatVersion = 1
+
+// layoutJSON is the JSON layout format. Characters are sorted by encoding/json,
+// so converting the same layout twice gives the same file.
+type layoutJSON struct {
+	Version int                    `json:"version"`
+	Name    string                 `json:"name"`
+	Chars   map[string][]Keystroke `json:"chars"`
+}
+
+func (l *Layout) MarshalJSON() ([]byte, error) {
+	lj := layoutJSON{Version: LayoutFormatVersion, Name: l.Name, Chars: map[string][]Keystroke{}}
+	for r, ks := range l.runes {
+		lj.Chars[string(r)] = ks
+	}
+	return json.Marshal(lj)
+}
+
+func (l *Layout) UnmarshalJSON(b []byte) error {
+	var lj layoutJSON
+	if err := json.Unmarshal(b, &lj); err != nil {
+		return err
+	}
+	if lj.Version != LayoutFormatVersion {
+		return fmt.Errorf("unsupported layout format version %d", lj.Version)
+	}
+	if lj.Name == "" {
+		return fmt.Errorf("layout has no name")
+	}
+	*l = *NewLayout(lj.Name)
+	for s, ks := range lj.Chars {
+		r, size := utf8.DecodeRuneInString(s)
+		if size == 0 || size != len(s) {
+			return fmt.Errorf("layout %s: %q is not a single character", lj.Name, s)
+		}
+		if len(ks) == 0 {
+			return fmt.Errorf("layout %s: no keystrokes for %q", lj.Name, s)
+		}
+		for _, k := range ks {
+			if _, kind := Convert(k.Key); kind == UNKNOWN {
+				return fmt.Errorf("layout %s: unsupported key %s for %q", lj.Name, k.Key, s)
+			}
+			switch k.Action {
+			case "", KeyActionDown:
+			case KeyActionUp:
+				// releasing a key does not release modifiers, they would
+				// stay held
+				if k.Modifiers != 0 {
+					return fmt.Errorf("layout %s: modifiers on the release of %s for %q", lj.Name, k.Key, s)
+				}
+			default:
+				return fmt.Errorf("layout %s: unknown action %q for %q, expected %s or %s", lj.Name, k.Action, s, KeyActionDown, KeyActionUp)
+			}
+		}
+		l.runes[r] = ks
+	}
+	return nil
+}
+
+// LoadLayouts registers the JSON layouts in the *.json files of dir and
+// returns their names. A layout named like a built-in layout is an error
+// unless override is set, then it replaces the built-in one.
+func LoadLayouts(dir string, override bool) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		l := &Layout{}
+		if err := json.Unmarshal(b, l); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if builtinLayouts[strings.ToLower(l.Name)] && !override {
+			return nil, fmt.Errorf("%s: layout %s would replace the built-in layout of the same name", file, l.Name)
+		}
+		RegisterLayout(l)
+		names = append(names, l.Name)
+	}
+	return names, nil
+}
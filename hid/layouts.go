@@ -0,0 +1,178 @@
+package hid
+
+import "fmt"
+
+// layoutRows are the keys of the four character rows of a keyboard in the
+// order of the level strings of a layoutDef. KEY_YEN, KEY_102ND and KEY_RO
+// only exist on JIS and ISO keyboards.
+var layoutRows = [4][]string{
+	{"KEY_GRAVE", "KEY_1", "KEY_2", "KEY_3", "KEY_4", "KEY_5", "KEY_6", "KEY_7", "KEY_8", "KEY_9", "KEY_0", "KEY_MINUS", "KEY_EQUAL", "KEY_YEN"},
+	{"KEY_Q", "KEY_W", "KEY_E", "KEY_R", "KEY_T", "KEY_Y", "KEY_U", "KEY_I", "KEY_O", "KEY_P", "KEY_LEFTBRACE", "KEY_RIGHTBRACE"},
+	{"KEY_A", "KEY_S", "KEY_D", "KEY_F", "KEY_G", "KEY_H", "KEY_J", "KEY_K", "KEY_L", "KEY_SEMICOLON", "KEY_APOSTROPHE", "KEY_BACKSLASH"},
+	{"KEY_102ND", "KEY_Z", "KEY_X", "KEY_C", "KEY_V", "KEY_B", "KEY_N", "KEY_M", "KEY_COMMA", "KEY_DOT", "KEY_SLASH", "KEY_RO"},
+}
+
+// layoutLevel lists the characters typed by the keys of layoutRows while
+// modifiers are held, a space marks keys without a character
+type layoutLevel struct {
+	modifiers byte
+	rows      [4]string
+}
+
+type layoutDef struct {
+	name   string
+	levels []layoutLevel
+	// dead are the dead keys, they change the character typed next
+	dead map[rune]Keystroke
+}
+
+// deadCompositions lists the characters a dead key makes of the following
+// one, as pairs of the following and the composed character
+var deadCompositions = map[rune]string{
+	'^': "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+	'´': "aáeéiíoóuúyýAÁEÉIÍOÓUÚYÝ",
+	'`': "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+	'¨': "aäeëiïoöuüyÿAÄEËIÏOÖUÜ",
+	'~': "aãoõnñAÃOÕNÑ",
+	'¸': "cçCÇ",
+	'ˇ': "cčsšzžeěrřnňCČSŠZŽEĚRŘNŇ",
+	'˚': "aåuůAÅUŮ",
+}
+
+// AddDeadKey adds the characters typed with the dead key for accent, the
+// accent itself and the accented letters the layout has a base letter for
+func (l *Layout) AddDeadKey(accent rune, dead Keystroke) {
+	// a dead key followed by space types the accent itself
+	l.Add(accent, dead, Keystroke{Key: "KEY_SPACE"})
+	pairs := []rune(deadCompositions[accent])
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if base, ok := l.Keystrokes(pairs[i]); ok && len(base) == 1 {
+			l.Add(pairs[i+1], dead, base[0])
+		}
+	}
+}
+
+func (def layoutDef) build() *Layout {
+	l := NewLayout(def.name)
+	for _, level := range def.levels {
+		for i, row := range level.rows {
+			keys := []rune(row)
+			if len(keys) != len(layoutRows[i]) {
+				panic(fmt.Sprintf("layout %s: row %d has %d keys instead of %d", def.name, i, len(keys), len(layoutRows[i])))
+			}
+			for j, r := range keys {
+				if r != ' ' {
+					l.Add(r, Keystroke{Key: layoutRows[i][j], Modifiers: level.modifiers})
+				}
+			}
+		}
+	}
+	for accent, dead := range def.dead {
+		l.AddDeadKey(accent, dead)
+	}
+	return l
+}
+
+const (
+	shift      = ModLeftShift
+	altGr      = ModAltGr
+	shiftAltGr = ModLeftShift | ModAltGr
+)
+
+var layoutDefs = []layoutDef{
+	{
+		name: "us",
+		levels: []layoutLevel{
+			{0, [4]string{"`1234567890-= ", "qwertyuiop[]", "asdfghjkl;'\\", " zxcvbnm,./ "}},
+			{shift, [4]string{"~!@#$%^&*()_+ ", "QWERTYUIOP{}", "ASDFGHJKL:\"|", " ZXCVBNM<>? "}},
+		},
+	},
+	{
+		name: "uk",
+		levels: []layoutLevel{
+			{0, [4]string{"`1234567890-= ", "qwertyuiop[]", "asdfghjkl;'#", "\\zxcvbnm,./ "}},
+			{shift, [4]string{"¬!\"£$%^&*()_+ ", "QWERTYUIOP{}", "ASDFGHJKL:@~", "|ZXCVBNM<>? "}},
+			{altGr, [4]string{"¦   €         ", "  é   úíó   ", "á           ", "            "}},
+		},
+	},
+	{
+		name: "de",
+		levels: []layoutLevel{
+			{0, [4]string{" 1234567890ß  ", "qwertzuiopü+", "asdfghjklöä#", "<yxcvbnm,.- "}},
+			{shift, [4]string{"°!\"§$%&/()=?  ", "QWERTZUIOPÜ*", "ASDFGHJKLÖÄ'", ">YXCVBNM;:_ "}},
+			{altGr, [4]string{"  ²³   {[]}\\  ", "@ €        ~", "            ", "|      µ    "}},
+		},
+		dead: map[rune]Keystroke{
+			'^': {Key: "KEY_GRAVE"},
+			'´': {Key: "KEY_EQUAL"},
+			'`': {Key: "KEY_EQUAL", Modifiers: shift},
+		},
+	},
+	{
+		name: "fr",
+		levels: []layoutLevel{
+			{0, [4]string{"²&é\"'(-è_çà)= ", "azertyuiop $", "qsdfghjklmù*", "<wxcvbn,;:! "}},
+			{shift, [4]string{" 1234567890°+ ", "AZERTYUIOP £", "QSDFGHJKLM%µ", ">WXCVBN?./§ "}},
+			{altGr, [4]string{"   #{[| \\^@]} ", "  €        ¤", "            ", "            "}},
+		},
+		dead: map[rune]Keystroke{
+			'^': {Key: "KEY_LEFTBRACE"},
+			'¨': {Key: "KEY_LEFTBRACE", Modifiers: shift},
+			'~': {Key: "KEY_2", Modifiers: altGr},
+			'`': {Key: "KEY_7", Modifiers: altGr},
+		},
+	},
+	{
+		name: "es",
+		levels: []layoutLevel{
+			{0, [4]string{"º1234567890'¡ ", "qwertyuiop +", "asdfghjklñ ç", "<zxcvbnm,.- "}},
+			{shift, [4]string{"ª!\"·$%&/()=?¿ ", "QWERTYUIOP *", "ASDFGHJKLÑ Ç", ">ZXCVBNM;:_ "}},
+			{altGr, [4]string{"\\|@#~€¬       ", "  €       []", "          {}", "            "}},
+		},
+		dead: map[rune]Keystroke{
+			'`': {Key: "KEY_LEFTBRACE"},
+			'^': {Key: "KEY_LEFTBRACE", Modifiers: shift},
+			'´': {Key: "KEY_APOSTROPHE"},
+			'¨': {Key: "KEY_APOSTROPHE", Modifiers: shift},
+		},
+	},
+	{
+		name: "it",
+		levels: []layoutLevel{
+			{0, [4]string{"\\1234567890'ì ", "qwertyuiopè+", "asdfghjklòàù", "<zxcvbnm,.- "}},
+			{shift, [4]string{"|!\"£$%&/()=?^ ", "QWERTYUIOPé*", "ASDFGHJKLç°§", ">ZXCVBNM;:_ "}},
+			{altGr, [4]string{"              ", "  €       []", "         @# ", "            "}},
+			{shiftAltGr, [4]string{"              ", "          {}", "            ", "            "}},
+		},
+	},
+	{
+		// Swedish and Finnish, Norwegian and Danish differ in a few keys
+		name: "nordic",
+		levels: []layoutLevel{
+			{0, [4]string{"§1234567890+  ", "qwertyuiopå ", "asdfghjklöä'", "<zxcvbnm,.- "}},
+			{shift, [4]string{"½!\"#¤%&/()=?  ", "QWERTYUIOPÅ ", "ASDFGHJKLÖÄ*", ">ZXCVBNM;:_ "}},
+			{altGr, [4]string{"  @£$€ {[]}\\  ", "  €         ", "            ", "|      µ    "}},
+		},
+		dead: map[rune]Keystroke{
+			'´': {Key: "KEY_EQUAL"},
+			'`': {Key: "KEY_EQUAL", Modifiers: shift},
+			'¨': {Key: "KEY_RIGHTBRACE"},
+			'^': {Key: "KEY_RIGHTBRACE", Modifiers: shift},
+			'~': {Key: "KEY_RIGHTBRACE", Modifiers: altGr},
+		},
+	},
+	{
+		name: "jis",
+		levels: []layoutLevel{
+			{0, [4]string{" 1234567890-^¥", "qwertyuiop@[", "asdfghjkl;:]", " zxcvbnm,./\\"}},
+			{shift, [4]string{" !\"#$%&'() =~|", "QWERTYUIOP`{", "ASDFGHJKL+*}", " ZXCVBNM<>?_"}},
+		},
+	},
+}
+
+func init() {
+	for _, def := range layoutDefs {
+		RegisterLayout(def.build())
+		builtinLayouts[def.name] = true
+	}
+}
@@ -0,0 +1,190 @@
+package hid
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MouseButton is a bit of the buttons byte of the mouse report
+type MouseButton byte
+
+const (
+	MouseButtonLeft   MouseButton = 1 << 0
+	MouseButtonRight  MouseButton = 1 << 1
+	MouseButtonMiddle MouseButton = 1 << 2
+)
+
+var mouseButtons = map[string]MouseButton{
+	"left":   MouseButtonLeft,
+	"right":  MouseButtonRight,
+	"middle": MouseButtonMiddle,
+}
+
+// ParseMouseButton returns the button called left, right or middle
+func ParseMouseButton(name string) (MouseButton, error) {
+	b, ok := mouseButtons[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown mouse button %q, expected left, right or middle", name)
+	}
+	return b, nil
+}
+
+// maxMouseStep is the largest movement or wheel step of one mouse report
+const maxMouseStep = 127
+
+// Mouse moves the pointer of the hosts with the mouse collection of the
+// descriptor in sdp_record.xml
+type Mouse interface {
+	// Move moves the pointer relative to its position, large moves are sent
+	// in several reports
+	Move(dx, dy int) error
+	// Click presses and releases a button
+	Click(button MouseButton) error
+	// ButtonDown presses a button and holds it until ButtonUp
+	ButtonDown(button MouseButton) error
+	ButtonUp(button MouseButton) error
+	// Scroll turns the wheel, positive values scroll up. Hosts using the
+	// boot protocol have no wheel.
+	Scroll(dy int) error
+	// Drag moves the pointer while button is held
+	Drag(button MouseButton, dx, dy int) error
+	// Target returns a mouse that moves the pointer of the host with the
+	// given address or BlueZ device path only, an empty target all hosts
+	Target(target string) (Mouse, error)
+}
+
+// mouseSteps splits a move into steps of at most maxMouseStep in each
+// direction. The steps are spread evenly so the pointer moves in a line.
+func mouseSteps(dx, dy int) [][2]int8 {
+	n := (abs(dx) + maxMouseStep - 1) / maxMouseStep
+	if m := (abs(dy) + maxMouseStep - 1) / maxMouseStep; m > n {
+		n = m
+	}
+	steps := make([][2]int8, n)
+	for i := range steps {
+		steps[i][0] = int8(dx*(i+1)/n - dx*i/n)
+		steps[i][1] = int8(dy*(i+1)/n - dy*i/n)
+	}
+	return steps
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// sendMouse sends a mouse report with the held buttons changed by f and
+// the given movement
+func (c *Connection) sendMouse(f func(buttons MouseButton) MouseButton, dx, dy, wheel int8) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	c.buttons = f(c.buttons)
+	out := c.out
+	report := mouseReport(c.protocol, []byte{byte(c.buttons), byte(dx), byte(dy), byte(wheel)})
+	c.mu.Unlock()
+	if out == nil {
+		return nil
+	}
+	log.Debugf("%x", report)
+	_, err := out.Write(append([]byte{0xA1}, report...))
+	return err
+}
+
+func keepButtons(buttons MouseButton) MouseButton {
+	return buttons
+}
+
+// move moves the pointer in steps while the held buttons stay held
+func (c *Connection) move(dx, dy int) error {
+	for _, step := range mouseSteps(dx, dy) {
+		if err := c.sendMouse(keepButtons, step[0], step[1], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Connection) buttonDown(button MouseButton) error {
+	return c.sendMouse(func(buttons MouseButton) MouseButton { return buttons | button }, 0, 0, 0)
+}
+
+func (c *Connection) buttonUp(button MouseButton) error {
+	return c.sendMouse(func(buttons MouseButton) MouseButton { return buttons &^ button }, 0, 0, 0)
+}
+
+func (c *Connection) scroll(dy int) error {
+	if c.Protocol() == ProtocolBoot {
+		log.Debug("Boot mouse has no wheel, not scrolling")
+		return nil
+	}
+	for _, step := range mouseSteps(0, dy) {
+		if err := c.sendMouse(keepButtons, 0, 0, step[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetMouse moves the pointer of the hosts of an adapter matching target,
+// all hosts if it is empty
+type targetMouse struct {
+	adapter *BluetoothKeyboardAdapter
+	target  string
+}
+
+// Mouse returns the mouse of the hosts connected to the keyboard
+func (ba *BluetoothKeyboardAdapter) Mouse() Mouse {
+	return &targetMouse{adapter: ba}
+}
+
+func (tm *targetMouse) Move(dx, dy int) error {
+	return tm.adapter.each(tm.target, func(c *Connection) error { return c.move(dx, dy) })
+}
+
+func (tm *targetMouse) Click(button MouseButton) error {
+	return tm.adapter.each(tm.target, func(c *Connection) error {
+		if err := c.buttonDown(button); err != nil {
+			return err
+		}
+		return c.buttonUp(button)
+	})
+}
+
+func (tm *targetMouse) ButtonDown(button MouseButton) error {
+	return tm.adapter.each(tm.target, func(c *Connection) error { return c.buttonDown(button) })
+}
+
+func (tm *targetMouse) ButtonUp(button MouseButton) error {
+	return tm.adapter.each(tm.target, func(c *Connection) error { return c.buttonUp(button) })
+}
+
+func (tm *targetMouse) Scroll(dy int) error {
+	return tm.adapter.each(tm.target, func(c *Connection) error { return c.scroll(dy) })
+}
+
+func (tm *targetMouse) Drag(button MouseButton, dx, dy int) error {
+	return tm.adapter.each(tm.target, func(c *Connection) error {
+		if err := c.buttonDown(button); err != nil {
+			return err
+		}
+		// the button is released even if the move fails
+		err := c.move(dx, dy)
+		if upErr := c.buttonUp(button); err == nil {
+			err = upErr
+		}
+		return err
+	})
+}
+
+func (tm *targetMouse) Target(target string) (Mouse, error) {
+	target = strings.TrimSpace(target)
+	if target != "" && len(tm.adapter.connections(target)) == 0 {
+		return nil, ErrUnknownHost
+	}
+	return &targetMouse{adapter: tm.adapter, target: target}, nil
+}
@@ -0,0 +1,60 @@
+package hid
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMouseSteps(t *testing.T) {
+	cases := []struct {
+		dx, dy int
+		want   [][2]int8
+	}{
+		{0, 0, [][2]int8{}},
+		{10, -5, [][2]int8{{10, -5}}},
+		{127, -127, [][2]int8{{127, -127}}},
+		{300, 30, [][2]int8{{100, 10}, {100, 10}, {100, 10}}},
+		{-128, 1, [][2]int8{{-64, 0}, {-64, 1}}},
+	}
+	for _, c := range cases {
+		if got := mouseSteps(c.dx, c.dy); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d,%d: expected %v, got %v", c.dx, c.dy, c.want, got)
+		}
+	}
+}
+
+func TestMouseDrag(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	conn, rec := readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:FF"})
+
+	mouse, err := adapter.Mouse().Target("aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mouse.Drag(MouseButtonLeft, 200, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mouse.Scroll(-3); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec, "a10101000000", "a10101640000", "a10101640000", "a10100000000", "a101000000fd")
+
+	// boot mice have no wheel and use report ID 2
+	rec.frames = nil
+	conn.SetProtocol(ProtocolBoot)
+	mouse.ButtonDown(MouseButtonRight)
+	mouse.Scroll(1)
+	assertFrames(t, rec, "a102020000")
+	report, err := conn.GetReport(ReportTypeInput, BootReportIDMouse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%x", report); got != "02020000" {
+		t.Errorf("GET_REPORT should return the held buttons, got %s", got)
+	}
+
+	if _, err := adapter.Mouse().Target("11:22:33:44:55:66"); err != ErrUnknownHost {
+		t.Errorf("expected ErrUnknownHost, got %v", err)
+	}
+}
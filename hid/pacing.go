@@ -0,0 +1,186 @@
+package hid
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// Pacing slows reports down when the send queue of the connection grows.
+// Some hosts, especially iOS, drop or reorder keystrokes when reports arrive
+// faster than they acknowledge them.
+type Pacing struct {
+	// LowWater is the queue depth in bytes at which reports start being delayed
+	LowWater int
+	// HighWater is the queue depth in bytes at which a report waits until the
+	// queue drained below it
+	HighWater int
+	// MaxDelay is the delay of a report just below HighWater, the delay grows
+	// linearly from LowWater
+	MaxDelay time.Duration
+	// PollInterval is how often the queue is checked while waiting at HighWater
+	PollInterval time.Duration
+	// MaxWait limits the time a report waits for the queue to drain
+	MaxWait time.Duration
+}
+
+// DefaultPacing leaves room for a handful of queued reports, every queued
+// report takes several hundred bytes of socket memory
+var DefaultPacing = Pacing{
+	LowWater:     2048,
+	HighWater:    8192,
+	MaxDelay:     20 * time.Millisecond,
+	PollInterval: 5 * time.Millisecond,
+	MaxWait:      2 * time.Second,
+}
+
+// PacingStats describes the send queue and the throttling applied to it
+type PacingStats struct {
+	QueueDepth     int           `json:"queueDepth"`
+	MaxQueueDepth  int           `json:"maxQueueDepth"`
+	Throttled      bool          `json:"throttled"`
+	ReportsSent    uint64        `json:"reportsSent"`
+	ReportsDelayed uint64        `json:"reportsDelayed"`
+	ThrottledTime  time.Duration `json:"throttledTime"`
+}
+
+// add sums the counters of o into s, the queue depths of hosts add up too
+func (s *PacingStats) add(o PacingStats) {
+	s.QueueDepth += o.QueueDepth
+	if o.MaxQueueDepth > s.MaxQueueDepth {
+		s.MaxQueueDepth = o.MaxQueueDepth
+	}
+	s.Throttled = s.Throttled || o.Throttled
+	s.ReportsSent += o.ReportsSent
+	s.ReportsDelayed += o.ReportsDelayed
+	s.ThrottledTime += o.ThrottledTime
+}
+
+// outQueuer is implemented by transports that know their send queue depth,
+// like *bluetooth.Bluetooth
+type outQueuer interface {
+	OutQueue() (int, error)
+}
+
+// outQueueOf finds the send queue of t, looking through wrapping transports
+// like the ones recording a capture
+func outQueueOf(t transport.Transport) outQueuer {
+	for t != nil {
+		if q, ok := t.(outQueuer); ok {
+			return q
+		}
+		u, ok := t.(interface{ Unwrap() transport.Transport })
+		if !ok {
+			return nil
+		}
+		t = u.Unwrap()
+	}
+	return nil
+}
+
+// pacer delays writes according to Pacing and keeps the statistics
+type pacer struct {
+	mu     sync.Mutex
+	pacing Pacing
+	stats  PacingStats
+	sleep  func(time.Duration)
+}
+
+func newPacer(pacing Pacing) *pacer {
+	return &pacer{pacing: pacing, sleep: time.Sleep}
+}
+
+func (p *pacer) setPacing(pacing Pacing) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pacing = pacing
+}
+
+func (p *pacer) Stats() PacingStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// sample records the queue depth, it returns false when the depth is unknown
+func (p *pacer) sample(q outQueuer) (int, bool) {
+	depth, err := q.OutQueue()
+	if err != nil {
+		log.Debug("Reading send queue failed: ", err)
+		return 0, false
+	}
+	p.mu.Lock()
+	p.stats.QueueDepth = depth
+	if depth > p.stats.MaxQueueDepth {
+		p.stats.MaxQueueDepth = depth
+	}
+	p.mu.Unlock()
+	return depth, true
+}
+
+func (p *pacer) setThrottled(throttled bool) {
+	p.mu.Lock()
+	p.stats.Throttled = throttled
+	p.mu.Unlock()
+}
+
+// wait blocks until the next report may be sent on q
+func (p *pacer) wait(q outQueuer) {
+	p.mu.Lock()
+	pacing := p.pacing
+	p.mu.Unlock()
+
+	depth, ok := p.sample(q)
+	if !ok || depth <= pacing.LowWater {
+		return
+	}
+	start := time.Now()
+	p.setThrottled(true)
+	if depth < pacing.HighWater {
+		p.sleep(time.Duration(int64(pacing.MaxDelay) * int64(depth-pacing.LowWater) / int64(pacing.HighWater-pacing.LowWater)))
+	} else {
+		log.Debugf("Send queue at %d bytes, waiting for the host", depth)
+		for depth >= pacing.HighWater && time.Since(start) < pacing.MaxWait {
+			p.sleep(pacing.PollInterval)
+			if depth, ok = p.sample(q); !ok {
+				break
+			}
+		}
+	}
+	p.mu.Lock()
+	p.stats.Throttled = false
+	p.stats.ReportsDelayed++
+	p.stats.ThrottledTime += time.Since(start)
+	p.mu.Unlock()
+}
+
+func (p *pacer) sent() {
+	p.mu.Lock()
+	p.stats.ReportsSent++
+	p.mu.Unlock()
+}
+
+// pacedTransport waits for the pacer before every write
+type pacedTransport struct {
+	transport.Transport
+	pacer *pacer
+	queue outQueuer
+}
+
+func (t *pacedTransport) Write(b []byte) (int, error) {
+	if t.queue != nil {
+		t.pacer.wait(t.queue)
+	}
+	n, err := t.Transport.Write(b)
+	if err == nil {
+		t.pacer.sent()
+	}
+	return n, err
+}
+
+// Unwrap returns the paced transport
+func (t *pacedTransport) Unwrap() transport.Transport {
+	return t.Transport
+}
@@ -0,0 +1,112 @@
+package hid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+)
+
+// queueTransport reports a scripted send queue depth before every write
+type queueTransport struct {
+	transport.Transport
+	depths []int
+}
+
+func (q *queueTransport) OutQueue() (int, error) {
+	depth := q.depths[0]
+	if len(q.depths) > 1 {
+		q.depths = q.depths[1:]
+	}
+	return depth, nil
+}
+
+func TestPacing(t *testing.T) {
+	pacing := Pacing{LowWater: 100, HighWater: 300, MaxDelay: 20 * time.Millisecond, PollInterval: time.Millisecond, MaxWait: time.Second}
+	cases := []struct {
+		name   string
+		depths []int
+		sleeps []time.Duration
+	}{
+		{"empty queue", []int{0}, nil},
+		{"below low water", []int{100}, nil},
+		{"between water marks", []int{200}, []time.Duration{10 * time.Millisecond}},
+		{"at high water", []int{300, 400, 299}, []time.Duration{time.Millisecond, time.Millisecond}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var sleeps []time.Duration
+			p := newPacer(pacing)
+			p.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+			host, device := transport.Pipe()
+			defer host.Close()
+			paced := &pacedTransport{Transport: device, pacer: p}
+			paced.queue = outQueueOf(&queueTransport{Transport: device, depths: c.depths})
+
+			if _, err := paced.Write([]byte{0xa1}); err != nil {
+				t.Fatal(err)
+			}
+			if len(sleeps) != len(c.sleeps) {
+				t.Fatalf("expected sleeps %v, got %v", c.sleeps, sleeps)
+			}
+			for i := range sleeps {
+				if sleeps[i] != c.sleeps[i] {
+					t.Errorf("expected sleeps %v, got %v", c.sleeps, sleeps)
+				}
+			}
+			stats := p.Stats()
+			if stats.ReportsSent != 1 || stats.Throttled {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+			if delayed := stats.ReportsDelayed == 1; delayed != (len(c.sleeps) > 0) {
+				t.Errorf("unexpected delayed count %+v", stats)
+			}
+			if stats.QueueDepth != c.depths[len(c.depths)-1] {
+				t.Errorf("expected queue depth %d, got %d", c.depths[len(c.depths)-1], stats.QueueDepth)
+			}
+		})
+	}
+}
+
+func TestPacingPerHost(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	first, _ := readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:01"})
+	second, _ := readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:02"})
+
+	keyboard, err := adapter.Target("AA:BB:CC:DD:EE:02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyboard.TypeKey("KEY_A"); err != nil {
+		t.Fatal(err)
+	}
+	// only the host typed to counts the reports
+	if sent := first.Status().Pacing.ReportsSent; sent != 0 {
+		t.Errorf("expected no reports sent to the first host, got %d", sent)
+	}
+	if sent := second.Status().Pacing.ReportsSent; sent != 2 {
+		t.Errorf("expected 2 reports sent to the second host, got %d", sent)
+	}
+
+	// the totals of the keyboard survive the host reconnecting
+	second.SetState(SessionClosed)
+	readyConnection(t, adapter, Host{Address: "AA:BB:CC:DD:EE:02"})
+	if sent := adapter.Status().Pacing.ReportsSent; sent != 2 {
+		t.Errorf("expected 2 reports sent in total, got %d", sent)
+	}
+	if err := adapter.TypeKey("KEY_A"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := adapter.Status().Pacing.ReportsSent; sent != 6 {
+		t.Errorf("expected 6 reports sent in total, got %d", sent)
+	}
+
+	pacing := Pacing{LowWater: 1, HighWater: 2}
+	adapter.SetPacing(pacing)
+	third := adapter.NewConnection(Host{Address: "AA:BB:CC:DD:EE:03"})
+	for _, c := range []*Connection{first, third} {
+		if c.pacer.pacing != pacing {
+			t.Errorf("expected pacing %+v for %s, got %+v", pacing, c.Host().Address, c.pacer.pacing)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package hid
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReportType is the report type of GET_REPORT, SET_REPORT and DATA messages
+type ReportType byte
+
+const (
+	ReportTypeOther   ReportType = 0
+	ReportTypeInput   ReportType = 1
+	ReportTypeOutput  ReportType = 2
+	ReportTypeFeature ReportType = 3
+)
+
+// report IDs of the descriptor in sdp_record.xml
+const (
+	ReportIDMouse    = 0x01
+	ReportIDKeyboard = 0x02
+	ReportIDConsumer = 0x03
+	ReportIDSystem   = 0x04
+	ReportIDApple    = 0x05
+)
+
+// report IDs in boot protocol mode. The Bluetooth HID profile keeps a report
+// ID in front of boot reports, but fixes it to 1 for keyboards and 2 for mice
+// instead of taking it from the descriptor.
+const (
+	BootReportIDKeyboard = 0x01
+	BootReportIDMouse    = 0x02
+)
+
+// KeyboardUsageMax is the largest key usage of the keyboard report in
+// sdp_record.xml, hosts drop keys above it. It covers the International keys
+// of JIS and Korean keyboards.
+const KeyboardUsageMax = 0xa4
+
+// sizes of the reports without their report ID
+const (
+	mouseInputSize     = 4
+	keyboardInputSize  = 8
+	keyboardOutputSize = 1
+	// the consumer report holds one 16 bit usage
+	consumerInputSize = 2
+	// the boot mouse report has no wheel
+	bootMouseInputSize = 3
+)
+
+// Protocol is the HID protocol mode selected by the host
+type Protocol byte
+
+const (
+	ProtocolBoot   Protocol = 0
+	ProtocolReport Protocol = 1
+)
+
+func (p Protocol) String() string {
+	if p == ProtocolBoot {
+		return "boot"
+	}
+	return "report"
+}
+
+var (
+	// ErrInvalidReportID is returned for reports not defined by the descriptor
+	ErrInvalidReportID = errors.New("invalid report id")
+	// ErrInvalidParameter is returned for malformed report data
+	ErrInvalidParameter = errors.New("invalid parameter")
+)
+
+// reportIDs returns the keyboard and mouse report IDs of protocol mode p
+func reportIDs(p Protocol) (keyboard byte, mouse byte) {
+	if p == ProtocolBoot {
+		return BootReportIDKeyboard, BootReportIDMouse
+	}
+	return ReportIDKeyboard, ReportIDMouse
+}
+
+// keyboardReport puts the report ID of protocol mode p in front of an
+// 8 byte keyboard report, the layout is the same in both modes
+func keyboardReport(p Protocol, report []byte) []byte {
+	keyboard, _ := reportIDs(p)
+	return append([]byte{keyboard}, report...)
+}
+
+// mouseReport puts the report ID of protocol mode p in front of a 4 byte
+// mouse report, in boot mode the wheel is dropped
+func mouseReport(p Protocol, report []byte) []byte {
+	_, mouse := reportIDs(p)
+	if p == ProtocolBoot {
+		report = report[:bootMouseInputSize]
+	}
+	return append([]byte{mouse}, report...)
+}
+
+// GetReport returns the current report of the given type and ID, starting
+// with the report ID. IDs are those of the current protocol mode.
+func (c *Connection) GetReport(typ ReportType, id byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keyboard, mouse := reportIDs(c.protocol)
+	switch {
+	case typ == ReportTypeInput && id == keyboard:
+		return keyboardReport(c.protocol, c.keys.report()), nil
+	case typ == ReportTypeInput && id == mouse:
+		report := make([]byte, mouseInputSize)
+		report[0] = byte(c.buttons)
+		return mouseReport(c.protocol, report), nil
+	case typ == ReportTypeInput && id == ReportIDConsumer && c.protocol == ProtocolReport:
+		return consumerReport(c.keys.consumer), nil
+	case typ == ReportTypeInput && id == ReportIDSystem && c.protocol == ProtocolReport:
+		return systemReport(c.keys.system), nil
+	case typ == ReportTypeInput && id == ReportIDApple && c.protocol == ProtocolReport:
+		return appleReport(c.keys.apple), nil
+	case typ == ReportTypeOutput && id == keyboard:
+		return []byte{id, c.leds}, nil
+	}
+	return nil, ErrInvalidReportID
+}
+
+// SetReport receives a report from the host, only the keyboard's LED output
+// report can be set
+func (c *Connection) SetReport(typ ReportType, id byte, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keyboard, _ := reportIDs(c.protocol)
+	if typ != ReportTypeOutput || id != keyboard {
+		return ErrInvalidReportID
+	}
+	if len(data) != keyboardOutputSize {
+		return ErrInvalidParameter
+	}
+	// the upper three bits are padding
+	leds := data[0] & (LEDNumLock | LEDCapsLock | LEDScrollLock | LEDCompose | LEDKana)
+	if c.leds != leds {
+		log.Infof("Host LEDs changed: %+v", ledsFromReport(leds))
+	}
+	c.leds = leds
+	return nil
+}
+
+func (c *Connection) Protocol() Protocol {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol
+}
+
+func (c *Connection) SetProtocol(p Protocol) error {
+	if p != ProtocolBoot && p != ProtocolReport {
+		return ErrInvalidParameter
+	}
+	c.mu.Lock()
+	if c.protocol != p {
+		log.Infof("Host switched to protocol mode %s", p)
+	}
+	c.protocol = p
+	c.mu.Unlock()
+	c.updateRepeat()
+	return nil
+}
+
+// bits of the keyboard's LED output report
+const (
+	LEDNumLock    = 1 << 0
+	LEDCapsLock   = 1 << 1
+	LEDScrollLock = 1 << 2
+	LEDCompose    = 1 << 3
+	LEDKana       = 1 << 4
+)
+
+// LEDs is the LED state the host last sent in an output report
+type LEDs struct {
+	NumLock    bool `json:"numLock"`
+	CapsLock   bool `json:"capsLock"`
+	ScrollLock bool `json:"scrollLock"`
+	Compose    bool `json:"compose"`
+	Kana       bool `json:"kana"`
+}
+
+func ledsFromReport(b byte) LEDs {
+	return LEDs{
+		NumLock:    b&LEDNumLock != 0,
+		CapsLock:   b&LEDCapsLock != 0,
+		ScrollLock: b&LEDScrollLock != 0,
+		Compose:    b&LEDCompose != 0,
+		Kana:       b&LEDKana != 0,
+	}
+}
+
+// LEDs returns the LED state of the host, e.g. whether Caps Lock is on
+func (c *Connection) LEDs() LEDs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ledsFromReport(c.leds)
+}
@@ -0,0 +1,35 @@
+package hid
+
+import "fmt"
+
+// SessionState is the lifecycle state of the connection to a host
+type SessionState int
+
+const (
+	// SessionClosed means there is no connection
+	SessionClosed SessionState = iota
+	// SessionAccepting means a host opened the control channel and the
+	// interrupt channel is awaited
+	SessionAccepting
+	// SessionConnected means both channels are open and the session starts
+	SessionConnected
+	// SessionReady means reports are sent to the host
+	SessionReady
+	// SessionSuspended means the host sleeps and reports are held back
+	SessionSuspended
+	// SessionDisconnecting means the channels are being closed
+	SessionDisconnecting
+)
+
+var sessionStateNames = []string{"closed", "accepting", "connected", "ready", "suspended", "disconnecting"}
+
+func (s SessionState) String() string {
+	if s >= 0 && int(s) < len(sessionStateNames) {
+		return sessionStateNames[s]
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+func (s SessionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
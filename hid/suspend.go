@@ -0,0 +1,90 @@
+package hid
+
+import (
+	"io"
+	"sync"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+)
+
+// gate holds back reports while the host is suspended. Writes wait until the
+// host exits suspend instead of being dropped.
+type gate struct {
+	transport.Transport
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	suspended bool
+	released  bool
+}
+
+func newGate(t transport.Transport) *gate {
+	g := &gate{Transport: t}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *gate) Write(b []byte) (int, error) {
+	g.mu.Lock()
+	for g.suspended && !g.released {
+		g.cond.Wait()
+	}
+	released := g.released
+	g.mu.Unlock()
+	if released {
+		return 0, io.ErrClosedPipe
+	}
+	return g.Transport.Write(b)
+}
+
+func (g *gate) setSuspended(suspended bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.suspended = suspended
+	g.cond.Broadcast()
+}
+
+// release fails all waiting and future writes, it is called when the
+// connection is replaced or closed
+func (g *gate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.released = true
+	g.cond.Broadcast()
+}
+
+// Unwrap returns the gated transport
+func (g *gate) Unwrap() transport.Transport {
+	return g.Transport
+}
+
+// Suspend holds back all reports until Resume is called, the host sends
+// SUSPEND when it goes to sleep
+func (c *Connection) Suspend() {
+	c.mu.Lock()
+	g, r := c.gate, c.repeater
+	c.mu.Unlock()
+	// close the gate first, so no write gets to the repeater after pause
+	// unless it passed the gate already
+	if g != nil {
+		g.setSuspended(true)
+	}
+	if r != nil {
+		r.pause()
+	}
+}
+
+// Resume sends the reports held back since Suspend and repeats held keys
+// again at the current idle rate
+func (c *Connection) Resume() {
+	c.mu.Lock()
+	g, r := c.gate, c.repeater
+	c.mu.Unlock()
+	if g != nil {
+		g.setSuspended(false)
+	}
+	if r != nil {
+		r.unpause()
+	}
+	c.updateRepeat()
+}
@@ -0,0 +1,19 @@
+package hid
+
+// systemT maps power keys to their System Control usages on the Generic
+// Desktop page (0x01)
+var systemT = map[string]int{
+	"KEY_POWER":  0x81,
+	"KEY_SLEEP":  0x82,
+	"KEY_WAKEUP": 0x83,
+	// System Do Not Disturb toggles notifications
+	"KEY_DO_NOT_DISTURB": 0x9b,
+	"KEY_SPEAKER_MUTE":   0xa7,
+	"KEY_HIBERNATE":      0xa8,
+}
+
+// systemReport returns the system control report with one usage held, zero
+// if none. Boot protocol has no system control report.
+func systemReport(usage byte) []byte {
+	return []byte{ReportIDSystem, usage}
+}
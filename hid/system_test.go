@@ -0,0 +1,29 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSystemControlKeys(t *testing.T) {
+	adapter := NewBluetoothKeyboardAdapter()
+	conn, rec := readyConnection(t, adapter, Host{})
+
+	if err := adapter.KeyDown("KEY_SLEEP"); err != nil {
+		t.Fatal(err)
+	}
+	report, err := conn.GetReport(ReportTypeInput, ReportIDSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(report, []byte{ReportIDSystem, 0x82}) {
+		t.Errorf("GET_REPORT should return the held system key, got %x", report)
+	}
+	if err := adapter.KeyUp("KEY_SLEEP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.TypeKey("KEY_WAKEUP"); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, rec, "a10482", "a10400", "a10483", "a10400")
+}
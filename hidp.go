@@ -1,36 +1,76 @@
 package gobt
 
 import (
+	"context"
+	"errors"
 	"fmt"
-
-	"golang.org/x/sys/unix"
+	"path"
+	"sync"
 
 	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/capture"
 	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
 	"github.com/godbus/dbus"
 	log "github.com/sirupsen/logrus"
 )
 
+// RequiredSecurity is the BT_SECURITY level enforced on both HID channels,
+// on top of the RequireAuthentication flag of the profile registration
+const RequiredSecurity = bluetooth.SecurityMedium
+
 //https://git.kernel.org/pub/scm/bluetooth/bluez.git/tree/doc/profile-api.txt
 type HidProfile struct {
 	path dbus.ObjectPath
+	// bus is used to remove the pairing of hosts that unplugged, it may be nil
+	bus *dbus.Conn
 
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
 	gb map[dbus.ObjectPath]*GoBt
 
-	connIntr *bluetooth.Bluetooth
+	connIntr    *bluetooth.Listener
+	intr        *intrMatcher
+	reconnector *Reconnector
+	tap         *capture.Tap
 
-	sintr           *bluetooth.Bluetooth
-	sctrl           *bluetooth.Bluetooth
 	keyboardAdapter *hid.BluetoothKeyboardAdapter
 }
 
-func NewHidProfile(path string, connIntr *bluetooth.Bluetooth, keyboardAdapter *hid.BluetoothKeyboardAdapter) *HidProfile {
-	return &HidProfile{
-		path:            (dbus.ObjectPath)(path),
-		gb:              make(map[dbus.ObjectPath]*GoBt),
-		connIntr:        connIntr,
+// NewHidProfile creates the BlueZ profile object. Cancelling ctx aborts pending
+// accepts and ends all sessions created by the profile.
+func NewHidProfile(ctx context.Context, bus *dbus.Conn, path string, connIntr *bluetooth.Listener, keyboardAdapter *hid.BluetoothKeyboardAdapter) *HidProfile {
+	ctx, cancel := context.WithCancel(ctx)
+	tap := capture.NewTap()
+	reconnector := NewReconnector(ctx, keyboardAdapter, tap)
+	// page hosts from the adapter the profile listens on
+	reconnector.Dialer.LocalAddr = connIntr.Addr().(*bluetooth.Addr).Bdaddr
+	p := &HidProfile{
+		path:     (dbus.ObjectPath)(path),
+		bus:      bus,
+		ctx:      ctx,
+		cancel:   cancel,
+		gb:       make(map[dbus.ObjectPath]*GoBt),
+		connIntr: connIntr,
+		intr: newIntrMatcher(func(ctx context.Context) (intrConn, error) {
+			bt, err := connIntr.AcceptContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return bt, nil
+		}),
+		reconnector:     reconnector,
+		tap:             tap,
 		keyboardAdapter: keyboardAdapter,
 	}
+	go p.intr.run(ctx)
+	return p
+}
+
+// Capture gives access to the HIDP traffic capture of this profile's sessions
+func (p *HidProfile) Capture() *capture.Tap {
+	return p.tap
 }
 
 func (p *HidProfile) Path() dbus.ObjectPath {
@@ -44,51 +84,170 @@ func (p *HidProfile) Release() *dbus.Error {
 
 func (p *HidProfile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, fdProps map[string]dbus.Variant) *dbus.Error {
 	log.Debug("NewConnection", dev, fd, fdProps)
+	// the host came back by itself, no need to page it anymore
+	p.reconnector.Cancel()
 
-	var err error
-	p.sintr, err = p.connIntr.Accept()
+	sctrl, err := bluetooth.NewBluetoothSocket(int(fd))
 	if err != nil {
-		p.connIntr.Close()
-		log.Debug("Accept failed", err, bluetooth.PSMINTR)
-		return dbus.NewError(fmt.Sprintf("Accept failed: %v", bluetooth.PSMINTR), []interface{}{err})
+		// NewBluetoothSocket has already closed fd
+		log.Debug("NewBluetoothSocket failed", err, fd, fdProps)
+		return dbus.NewError(fmt.Sprintf("NewBluetoothSocket failed: %v, %v, %v", err, fd, fdProps), []interface{}{err})
+	}
+	log.Debug("Created New Ctrl Socket")
+
+	// the control channel is set up by bluetoothd, so enforce security on it here
+	if err := sctrl.SetSecurity(RequiredSecurity); err != nil {
+		sctrl.Close()
+		log.Debug("Setting security on ctrl socket failed", err)
+		return dbus.NewError(fmt.Sprintf("Setting security failed: %v", err), []interface{}{err})
 	}
-	log.Debug("Connection Accepted", bluetooth.PSMINTR)
 
-	p.sctrl, err = bluetooth.NewBluetoothSocket(int(fd))
+	host := sctrl.RemoteAddr().(*bluetooth.Addr).Bdaddr
+	gb := newSession(p.ctx, hid.Host{Address: host.String(), Device: string(dev)}, p.keyboardAdapter)
+	sintr, err := p.intr.match(p.ctx, host)
 	if err != nil {
-		_err := unix.Close(int(fd))
+		gb.Close()
+		sctrl.Close()
+		log.Debug("No interrupt channel for ", host, ": ", err)
+		return dbus.NewError("org.bluez.Error.Rejected", []interface{}{err.Error()})
+	}
+	log.Debug("Interrupt channel matched ", host)
+	logChannel("ctrl", sctrl)
+	if bt, ok := sintr.(*bluetooth.Bluetooth); ok {
+		logChannel("intr", bt)
+	}
 
-		if _err != nil {
-			log.Debug("NewBluetoothSocket closing fd failed", _err)
-			return dbus.NewError("NewBluetoothSocket closing fd failed", []interface{}{_err})
-		}
-		log.Debug("NewBluetoothSocket failed", err, fd, fdProps)
-		return dbus.NewError(fmt.Sprintf("NewBluetoothSocket failed: %v, %v, %v", err, fd, fdProps), []interface{}{err})
+	ctrl, intr := p.tap.Wrap(sctrl, sintr)
+	if !gb.start(intr, ctrl) {
+		gb.Close()
+		intr.Close()
+		ctrl.Close()
+		return dbus.NewError("HID session could not be started", nil)
 	}
-	log.Debug("Created New Ctrl Socket")
+	p.reconnector.SetHost(host, dev)
 
-	p.gb[dev] = NewGoBt(p.sintr, p.sctrl, p.keyboardAdapter)
+	p.mu.Lock()
+	p.gb[dev] = gb
+	p.mu.Unlock()
+	go p.watch(dev, gb)
 	return nil
 }
 
+func logChannel(name string, bt *bluetooth.Bluetooth) {
+	sec, err := bt.Security()
+	if err != nil {
+		log.Debug("Reading security of ", name, " failed ", err)
+		return
+	}
+	imtu, omtu, err := bt.MTU()
+	if err != nil {
+		log.Debug("Reading MTU of ", name, " failed ", err)
+		return
+	}
+	log.Debugf("%s channel to %s: security %s, key size %d, imtu %d, omtu %d", name, bt.RemoteAddr(), sec.Level, sec.KeySize, imtu, omtu)
+}
+
+// watch waits for a session to end and pages the host again if the link
+// was dropped without anybody asking for a disconnection
+func (p *HidProfile) watch(dev dbus.ObjectPath, gb *GoBt) {
+	<-gb.Done()
+
+	p.mu.Lock()
+	dropped := p.gb[dev] == gb
+	if dropped {
+		delete(p.gb, dev)
+	}
+	p.mu.Unlock()
+	if !dropped || p.ctx.Err() != nil {
+		return
+	}
+	last := p.reconnector.IsHost(dev)
+	if gb.Unplugged() {
+		// the virtual cable is gone, paging the host again would be pointless
+		if last {
+			p.reconnector.ForgetHost()
+		}
+		p.removeDevice(dev)
+		return
+	}
+	if !last {
+		// only the last host to connect is paged again, the others come
+		// back by themselves
+		log.Info("Host ", dev, " dropped the connection")
+		return
+	}
+
+	log.Info("Host dropped the connection, trying to reconnect")
+	gb, err := p.reconnector.Reconnect()
+	if err != nil {
+		log.Debug("Reconnect stopped: ", err)
+		return
+	}
+
+	p.mu.Lock()
+	p.gb[dev] = gb
+	p.mu.Unlock()
+	go p.watch(dev, gb)
+}
+
+// removeDevice deletes the pairing with dev, like the host did with ours
+func (p *HidProfile) removeDevice(dev dbus.ObjectPath) {
+	if p.bus == nil {
+		return
+	}
+	adapter := dbus.ObjectPath(path.Dir(string(dev)))
+	call := p.bus.Object("org.bluez", adapter).Call("org.bluez.Adapter1.RemoveDevice", 0, dev)
+	if call.Err != nil {
+		log.Warn("Removing ", dev, " failed: ", call.Err)
+		return
+	}
+	log.Info("Removed pairing of ", dev)
+}
+
 func (p *HidProfile) RequestDisconnection(dev dbus.ObjectPath) *dbus.Error {
 	log.Debug("RequestDisconnection", dev)
-	p.gb[dev].Close()
+	p.mu.Lock()
+	gb := p.gb[dev]
+	delete(p.gb, dev)
+	p.mu.Unlock()
+	if gb != nil {
+		gb.Close()
+	}
 	return nil
 }
 
+// Unplug sends a virtual cable unplug to all connected hosts, ends their
+// sessions and removes their pairing
+func (p *HidProfile) Unplug() error {
+	p.mu.Lock()
+	var sessions []*GoBt
+	for _, gb := range p.gb {
+		if gb != nil {
+			sessions = append(sessions, gb)
+		}
+	}
+	p.mu.Unlock()
+	if len(sessions) == 0 {
+		return errors.New("no host connected")
+	}
+	var err error
+	for _, gb := range sessions {
+		if e := gb.Unplug(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
 func (p *HidProfile) Close() {
 	log.Debug("Hid Profile will close")
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	for k := range p.gb {
-		p.gb[k].Close()
+		if p.gb[k] != nil {
+			p.gb[k].Close()
+		}
 		p.gb[k] = nil
 	}
-
-	if p.sintr != nil {
-		p.sintr.Close()
-	}
-
-	if p.sctrl != nil {
-		p.sctrl.Close()
-	}
 }
@@ -0,0 +1,173 @@
+package gobt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// IntrMatchTimeout is how long an interrupt channel waits for the control
+// channel of the same host and the other way round
+const IntrMatchTimeout = 10 * time.Second
+
+// intrConn is an accepted interrupt channel
+type intrConn interface {
+	transport.Transport
+	RemoteAddr() net.Addr
+}
+
+// intrMatcher accepts interrupt channels in the background and hands each one
+// to the control channel of the same host. The host may open the interrupt
+// channel before or after BlueZ passes the control channel to NewConnection.
+type intrMatcher struct {
+	accept  func(context.Context) (intrConn, error)
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[bluetooth.Bdaddr]*pendingIntr
+	waiting map[bluetooth.Bdaddr]chan intrConn
+}
+
+type pendingIntr struct {
+	conn  intrConn
+	timer *time.Timer
+}
+
+func newIntrMatcher(accept func(context.Context) (intrConn, error)) *intrMatcher {
+	return &intrMatcher{
+		accept:  accept,
+		timeout: IntrMatchTimeout,
+		pending: make(map[bluetooth.Bdaddr]*pendingIntr),
+		waiting: make(map[bluetooth.Bdaddr]chan intrConn),
+	}
+}
+
+func remoteBdaddr(c intrConn) (bluetooth.Bdaddr, bool) {
+	addr, ok := c.RemoteAddr().(*bluetooth.Addr)
+	if !ok {
+		return bluetooth.Bdaddr{}, false
+	}
+	return addr.Bdaddr, true
+}
+
+// run accepts interrupt channels until ctx is done or the listener is closed,
+// channels that are still pending then are closed
+func (m *intrMatcher) run(ctx context.Context) {
+	defer m.closePending()
+	for {
+		c, err := m.accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return
+			}
+			log.Debug("Accepting interrupt channel failed: ", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+		bdaddr, ok := remoteBdaddr(c)
+		if !ok {
+			log.Debug("Rejecting interrupt channel from unknown address ", c.RemoteAddr())
+			c.Close()
+			continue
+		}
+		log.Debug("Interrupt channel accepted from ", bdaddr)
+		m.deliver(bdaddr, c)
+	}
+}
+
+// deliver passes c to a waiting control channel or keeps it until one arrives
+func (m *intrMatcher) deliver(bdaddr bluetooth.Bdaddr, c intrConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ch, ok := m.waiting[bdaddr]; ok {
+		delete(m.waiting, bdaddr)
+		ch <- c
+		return
+	}
+	if old, ok := m.pending[bdaddr]; ok {
+		log.Debug("Replacing unmatched interrupt channel of ", bdaddr)
+		old.timer.Stop()
+		old.conn.Close()
+	}
+	p := &pendingIntr{conn: c}
+	p.timer = time.AfterFunc(m.timeout, func() {
+		m.mu.Lock()
+		expired := m.pending[bdaddr] == p
+		if expired {
+			delete(m.pending, bdaddr)
+		}
+		m.mu.Unlock()
+		if expired {
+			log.Info("Rejecting interrupt channel of ", bdaddr, ", no control channel within ", m.timeout)
+			c.Close()
+		}
+	})
+	m.pending[bdaddr] = p
+}
+
+// match returns the interrupt channel of bdaddr, waiting for it until the
+// timeout expires or ctx is done
+func (m *intrMatcher) match(ctx context.Context, bdaddr bluetooth.Bdaddr) (intrConn, error) {
+	m.mu.Lock()
+	if p, ok := m.pending[bdaddr]; ok {
+		delete(m.pending, bdaddr)
+		m.mu.Unlock()
+		p.timer.Stop()
+		return p.conn, nil
+	}
+	if _, ok := m.waiting[bdaddr]; ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("already waiting for the interrupt channel of %s", bdaddr)
+	}
+	ch := make(chan intrConn, 1)
+	m.waiting[bdaddr] = ch
+	m.mu.Unlock()
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case c := <-ch:
+		return c, nil
+	case <-timer.C:
+		err = fmt.Errorf("no interrupt channel from %s within %s", bdaddr, m.timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	m.mu.Lock()
+	// a channel delivered meanwhile lets the next call wait again
+	if m.waiting[bdaddr] == ch {
+		delete(m.waiting, bdaddr)
+	}
+	m.mu.Unlock()
+	// the channel may have been delivered while giving up
+	select {
+	case c := <-ch:
+		c.Close()
+	default:
+	}
+	return nil, err
+}
+
+func (m *intrMatcher) closePending() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for bdaddr, p := range m.pending {
+		p.timer.Stop()
+		p.conn.Close()
+		delete(m.pending, bdaddr)
+	}
+}
@@ -0,0 +1,110 @@
+package gobt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/transport"
+)
+
+type fakeIntr struct {
+	transport.Transport
+	addr *bluetooth.Addr
+}
+
+func (c *fakeIntr) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newFakeIntr(bdaddr bluetooth.Bdaddr) *fakeIntr {
+	_, device := transport.Pipe()
+	return &fakeIntr{Transport: device, addr: &bluetooth.Addr{Bdaddr: bdaddr, PSM: bluetooth.PSMINTR}}
+}
+
+func isClosed(c *fakeIntr) bool {
+	_, err := c.Write([]byte{0})
+	return err != nil
+}
+
+func startMatcher(t *testing.T, timeout time.Duration) (*intrMatcher, chan intrConn, context.CancelFunc) {
+	t.Helper()
+	accepted := make(chan intrConn)
+	m := newIntrMatcher(func(ctx context.Context) (intrConn, error) {
+		select {
+		case c := <-accepted:
+			return c, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	m.timeout = timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.run(ctx)
+	return m, accepted, cancel
+}
+
+func TestIntrMatchedByAddress(t *testing.T) {
+	m, accepted, cancel := startMatcher(t, time.Second)
+	defer cancel()
+	host1 := bluetooth.Bdaddr{1, 0, 0, 0, 0, 0}
+	host2 := bluetooth.Bdaddr{2, 0, 0, 0, 0, 0}
+	intr1, intr2 := newFakeIntr(host1), newFakeIntr(host2)
+
+	// host2 opens its interrupt channel first, it must not be given to host1
+	accepted <- intr2
+	matched := make(chan intrConn)
+	go func() {
+		c, err := m.match(context.Background(), host1)
+		if err != nil {
+			t.Error(err)
+		}
+		matched <- c
+	}()
+	accepted <- intr1
+
+	if c := <-matched; c != intr1 {
+		t.Error("host1 got the interrupt channel of another host")
+	}
+	if c, err := m.match(context.Background(), host2); err != nil || c != intr2 {
+		t.Errorf("host2 did not get its pending interrupt channel: %v", err)
+	}
+}
+
+func TestUnmatchedIntrRejected(t *testing.T) {
+	m, accepted, cancel := startMatcher(t, 10*time.Millisecond)
+	defer cancel()
+	host := bluetooth.Bdaddr{1, 0, 0, 0, 0, 0}
+	intr := newFakeIntr(host)
+
+	accepted <- intr
+	time.Sleep(50 * time.Millisecond)
+	if !isClosed(intr) {
+		t.Error("interrupt channel without control channel was not closed")
+	}
+	if _, err := m.match(context.Background(), host); err == nil {
+		t.Error("expected timeout for control channel without interrupt channel")
+	}
+}
+
+func TestPendingIntrClosedOnCancel(t *testing.T) {
+	m, accepted, cancel := startMatcher(t, time.Minute)
+	intr := newFakeIntr(bluetooth.Bdaddr{1, 0, 0, 0, 0, 0})
+	accepted <- intr
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for !isClosed(intr) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !isClosed(intr) {
+		t.Error("pending interrupt channel was not closed when the profile stopped")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) != 0 {
+		t.Errorf("expected no pending channels, got %d", len(m.pending))
+	}
+}
@@ -0,0 +1,88 @@
+package gobt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// ProfileMux is the org.bluez.Profile1 object registered with BlueZ when
+// several adapters run their own keyboard. BlueZ registers profiles for all
+// adapters at once, so the mux routes each call to the HidProfile of the
+// adapter that owns the device.
+type ProfileMux struct {
+	path dbus.ObjectPath
+
+	mu       sync.Mutex
+	profiles map[dbus.ObjectPath]*HidProfile
+}
+
+func NewProfileMux(path string) *ProfileMux {
+	return &ProfileMux{
+		path:     (dbus.ObjectPath)(path),
+		profiles: make(map[dbus.ObjectPath]*HidProfile),
+	}
+}
+
+func (m *ProfileMux) Path() dbus.ObjectPath {
+	return m.path
+}
+
+// Handle routes devices of adapter, e.g. /org/bluez/hci0, to profile.
+// An empty adapter path makes profile handle devices of all adapters
+// without their own profile.
+func (m *ProfileMux) Handle(adapter dbus.ObjectPath, profile *HidProfile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profiles[adapter] = profile
+}
+
+func (m *ProfileMux) route(dev dbus.ObjectPath) (*HidProfile, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for adapter, profile := range m.profiles {
+		if adapter != "" && strings.HasPrefix(string(dev), string(adapter)+"/") {
+			return profile, nil
+		}
+	}
+	if profile, ok := m.profiles[""]; ok {
+		return profile, nil
+	}
+	log.Debug("No profile for device ", dev)
+	return nil, dbus.NewError("org.bluez.Error.Rejected", []interface{}{fmt.Sprintf("no keyboard on the adapter of %s", dev)})
+}
+
+func (m *ProfileMux) Release() *dbus.Error {
+	log.Debug("Release")
+	return nil
+}
+
+func (m *ProfileMux) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, fdProps map[string]dbus.Variant) *dbus.Error {
+	profile, err := m.route(dev)
+	if err != nil {
+		unix.Close(int(fd))
+		return err
+	}
+	return profile.NewConnection(dev, fd, fdProps)
+}
+
+func (m *ProfileMux) RequestDisconnection(dev dbus.ObjectPath) *dbus.Error {
+	profile, err := m.route(dev)
+	if err != nil {
+		return err
+	}
+	return profile.RequestDisconnection(dev)
+}
+
+// Close closes all routed profiles
+func (m *ProfileMux) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, profile := range m.profiles {
+		profile.Close()
+	}
+}
@@ -0,0 +1,25 @@
+package gobt
+
+import "testing"
+
+func TestProfileMuxRoutesByAdapter(t *testing.T) {
+	hci0, hci1, fallback := &HidProfile{}, &HidProfile{}, &HidProfile{}
+	m := NewProfileMux("/test/profile")
+	m.Handle("/org/bluez/hci0", hci0)
+	m.Handle("/org/bluez/hci1", hci1)
+
+	if p, _ := m.route("/org/bluez/hci1/dev_AA_BB_CC_DD_EE_FF"); p != hci1 {
+		t.Error("device of hci1 was not routed to the hci1 profile")
+	}
+	if p, _ := m.route("/org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF"); p != hci0 {
+		t.Error("device of hci0 was not routed to the hci0 profile")
+	}
+	if _, err := m.route("/org/bluez/hci10/dev_AA_BB_CC_DD_EE_FF"); err == nil {
+		t.Error("device of an unknown adapter must be rejected")
+	}
+
+	m.Handle("", fallback)
+	if p, _ := m.route("/org/bluez/hci10/dev_AA_BB_CC_DD_EE_FF"); p != fallback {
+		t.Error("device of an unknown adapter was not routed to the fallback profile")
+	}
+}
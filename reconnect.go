@@ -0,0 +1,158 @@
+package gobt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/bluetooth"
+	"github.com/danielpaulus/software-bluetooth-keyboard/capture"
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	"github.com/godbus/dbus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMinBackoff = 1 * time.Second
+	DefaultMaxBackoff = 2 * time.Minute
+)
+
+// Reconnector re-opens the HID channels to the last connected host.
+// Our SDP record advertises HIDReconnectInitiate, so after the host drops
+// the link it is up to us to page it again.
+type Reconnector struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Dialer selects the local adapter and the security of the channels
+	Dialer bluetooth.Dialer
+
+	ctx             context.Context
+	keyboardAdapter *hid.BluetoothKeyboardAdapter
+	tap             *capture.Tap
+
+	mu      sync.Mutex
+	host    *bluetooth.Bdaddr
+	device  dbus.ObjectPath
+	attempt context.CancelFunc
+}
+
+// NewReconnector creates a Reconnector whose sessions live until ctx is done.
+// The channels of new sessions are recorded by tap.
+func NewReconnector(ctx context.Context, keyboardAdapter *hid.BluetoothKeyboardAdapter, tap *capture.Tap) *Reconnector {
+	return &Reconnector{
+		MinBackoff:      DefaultMinBackoff,
+		MaxBackoff:      DefaultMaxBackoff,
+		Dialer:          bluetooth.Dialer{Security: RequiredSecurity},
+		ctx:             ctx,
+		keyboardAdapter: keyboardAdapter,
+		tap:             tap,
+	}
+}
+
+// SetHost remembers the host that will be reconnected to and its BlueZ
+// device path
+func (r *Reconnector) SetHost(addr bluetooth.Bdaddr, dev dbus.ObjectPath) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.host = &addr
+	r.device = dev
+}
+
+// IsHost reports whether dev is the host that will be reconnected to
+func (r *Reconnector) IsHost(dev dbus.ObjectPath) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.host != nil && r.device == dev
+}
+
+// ForgetHost clears the host, e.g. after it unplugged the virtual cable
+func (r *Reconnector) ForgetHost() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.host = nil
+	r.device = ""
+}
+
+// Host returns the last connected host
+func (r *Reconnector) Host() (bluetooth.Bdaddr, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.host == nil {
+		return bluetooth.Bdaddr{}, false
+	}
+	return *r.host, true
+}
+
+// Reconnect dials the control and then the interrupt channel of the last
+// connected host and retries with exponential backoff until it succeeds,
+// Cancel is called or the Reconnector's context is done.
+func (r *Reconnector) Reconnect() (*GoBt, error) {
+	host, ok := r.Host()
+	if !ok {
+		return nil, errors.New("no host to reconnect to")
+	}
+	r.mu.Lock()
+	dev := r.device
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	r.mu.Lock()
+	if r.attempt != nil {
+		r.attempt()
+	}
+	r.attempt = cancel
+	r.mu.Unlock()
+
+	backoff := r.MinBackoff
+	for {
+		gb, err := r.dial(ctx, host, dev)
+		if err == nil {
+			log.Infof("Reconnected to %s", host)
+			return gb, nil
+		}
+		log.Debugf("Reconnect to %s failed: %v, retrying in %s", host, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// Cancel aborts a running Reconnect, e.g. because the host connected by itself
+func (r *Reconnector) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.attempt != nil {
+		r.attempt()
+		r.attempt = nil
+	}
+}
+
+func (r *Reconnector) dial(ctx context.Context, host bluetooth.Bdaddr, dev dbus.ObjectPath) (*GoBt, error) {
+	sctrl, err := r.Dialer.DialContext(ctx, host, bluetooth.PSMCTRL)
+	if err != nil {
+		return nil, err
+	}
+	sintr, err := r.Dialer.DialContext(ctx, host, bluetooth.PSMINTR)
+	if err != nil {
+		sctrl.Close()
+		return nil, err
+	}
+
+	ctrl, intr := r.tap.Wrap(sctrl, sintr)
+	gb := NewGoBt(r.ctx, hid.Host{Address: host.String(), Device: string(dev)}, intr, ctrl, r.keyboardAdapter)
+	if gb == nil {
+		ctrl.Close()
+		intr.Close()
+		return nil, errors.New("HID session could not be started")
+	}
+	return gb, nil
+}
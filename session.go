@@ -0,0 +1,106 @@
+package gobt
+
+import (
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	log "github.com/sirupsen/logrus"
+)
+
+// sessionEvent drives the session from one state to the next
+type sessionEvent int
+
+const (
+	// eventAccepting is raised when BlueZ passes the control channel
+	eventAccepting sessionEvent = iota
+	// eventConnected is raised when both channels are open
+	eventConnected
+	// eventHelloSent is raised when the host was greeted on the control channel
+	eventHelloSent
+	// eventSuspend and eventExitSuspend are raised by HID_CONTROL messages
+	eventSuspend
+	eventExitSuspend
+	// eventUnplug is raised by a virtual cable unplug from either side
+	eventUnplug
+	// eventSocketError is raised when a channel fails or the host hangs up
+	eventSocketError
+	// eventDisconnect is raised when BlueZ or we ask to end the session
+	eventDisconnect
+	// eventClosed is raised once both channels are closed
+	eventClosed
+)
+
+var sessionEventNames = []string{"accepting", "connected", "hello sent", "suspend", "exit suspend", "unplug", "socket error", "disconnect", "closed"}
+
+func (e sessionEvent) String() string {
+	return sessionEventNames[e]
+}
+
+// sessionTransitions lists the valid transitions, events missing for a state
+// are ignored
+var sessionTransitions = map[hid.SessionState]map[sessionEvent]hid.SessionState{
+	hid.SessionClosed: {
+		eventAccepting: hid.SessionAccepting,
+	},
+	hid.SessionAccepting: {
+		eventConnected:  hid.SessionConnected,
+		eventDisconnect: hid.SessionDisconnecting,
+		eventClosed:     hid.SessionClosed,
+	},
+	hid.SessionConnected: {
+		eventHelloSent:   hid.SessionReady,
+		eventSocketError: hid.SessionDisconnecting,
+		eventUnplug:      hid.SessionDisconnecting,
+		eventDisconnect:  hid.SessionDisconnecting,
+		eventClosed:      hid.SessionClosed,
+	},
+	hid.SessionReady: {
+		eventSuspend:     hid.SessionSuspended,
+		eventSocketError: hid.SessionDisconnecting,
+		eventUnplug:      hid.SessionDisconnecting,
+		eventDisconnect:  hid.SessionDisconnecting,
+		eventClosed:      hid.SessionClosed,
+	},
+	hid.SessionSuspended: {
+		eventExitSuspend: hid.SessionReady,
+		eventSocketError: hid.SessionDisconnecting,
+		eventUnplug:      hid.SessionDisconnecting,
+		eventDisconnect:  hid.SessionDisconnecting,
+		eventClosed:      hid.SessionClosed,
+	},
+	hid.SessionDisconnecting: {
+		eventClosed: hid.SessionClosed,
+	},
+}
+
+func nextSessionState(state hid.SessionState, event sessionEvent) (hid.SessionState, bool) {
+	next, ok := sessionTransitions[state][event]
+	return next, ok
+}
+
+// transition applies event to the session and reports the new state to the
+// connection of the session. It returns the previous state and whether the event was valid.
+func (gb *GoBt) transition(event sessionEvent) (hid.SessionState, bool) {
+	gb.stateMu.Lock()
+	defer gb.stateMu.Unlock()
+	prev := gb.state
+	next, ok := nextSessionState(prev, event)
+	if !ok {
+		log.Debugf("session: ignoring %s in state %s", event, prev)
+		return prev, false
+	}
+	gb.state = next
+	gb.conn.SetState(next)
+	log.Debugf("session: %s -> %s on %s", prev, next, event)
+	return prev, true
+}
+
+func (gb *GoBt) handle(event sessionEvent) bool {
+	_, ok := gb.transition(event)
+	return ok
+}
+
+// State returns the current state of the session
+func (gb *GoBt) State() hid.SessionState {
+	gb.stateMu.Lock()
+	defer gb.stateMu.Unlock()
+	return gb.state
+}
@@ -0,0 +1,123 @@
+package gobt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/software-bluetooth-keyboard/hid"
+	"github.com/godbus/dbus"
+)
+
+func TestSessionTransitions(t *testing.T) {
+	const invalid = hid.SessionState(-1)
+	states := []hid.SessionState{hid.SessionClosed, hid.SessionAccepting, hid.SessionConnected, hid.SessionReady, hid.SessionSuspended, hid.SessionDisconnecting}
+	events := []sessionEvent{eventAccepting, eventConnected, eventHelloSent, eventSuspend, eventExitSuspend, eventUnplug, eventSocketError, eventDisconnect, eventClosed}
+	// expected[state][event], one row per state in the order above
+	expected := [][]hid.SessionState{
+		// accepting, connected, hello sent, suspend, exit suspend, unplug, socket error, disconnect, closed
+		{hid.SessionAccepting, invalid, invalid, invalid, invalid, invalid, invalid, invalid, invalid},
+		{invalid, hid.SessionConnected, invalid, invalid, invalid, invalid, invalid, hid.SessionDisconnecting, hid.SessionClosed},
+		{invalid, invalid, hid.SessionReady, invalid, invalid, hid.SessionDisconnecting, hid.SessionDisconnecting, hid.SessionDisconnecting, hid.SessionClosed},
+		{invalid, invalid, invalid, hid.SessionSuspended, invalid, hid.SessionDisconnecting, hid.SessionDisconnecting, hid.SessionDisconnecting, hid.SessionClosed},
+		{invalid, invalid, invalid, invalid, hid.SessionReady, hid.SessionDisconnecting, hid.SessionDisconnecting, hid.SessionDisconnecting, hid.SessionClosed},
+		{invalid, invalid, invalid, invalid, invalid, invalid, invalid, invalid, hid.SessionClosed},
+	}
+	for i, state := range states {
+		for j, event := range events {
+			next, ok := nextSessionState(state, event)
+			if !ok {
+				next = invalid
+			}
+			if next != expected[i][j] {
+				t.Errorf("%s on %s: expected %s, got %s", state, event, expected[i][j], next)
+			}
+		}
+	}
+}
+
+func TestSessionReportsStateToKeyboard(t *testing.T) {
+	gb, host, adapter := newTestSession(t)
+	if s := adapter.Status(); s.State != hid.SessionReady || !s.IsReady {
+		t.Fatalf("expected a ready keyboard, got %+v", s)
+	}
+
+	host.ctrl.Write([]byte{HIDPTRANSHIDCONTROL | HIDPCTRLSUSPEND})
+	waitForState(t, adapter, hid.SessionSuspended)
+	if !adapter.Status().IsReady {
+		t.Error("a suspended keyboard must still report ready")
+	}
+	host.ctrl.Write([]byte{HIDPTRANSHIDCONTROL | HIDPCTRLEXITSUSPEND})
+	waitForState(t, adapter, hid.SessionReady)
+
+	host.intr.Close()
+	host.ctrl.Close()
+	waitForState(t, adapter, hid.SessionClosed)
+	if adapter.Status().IsReady {
+		t.Error("keyboard is still ready after the host hung up")
+	}
+	if gb.State() != hid.SessionClosed {
+		t.Errorf("expected closed session, got %s", gb.State())
+	}
+}
+
+func TestClosedSessionDoesNotHideNewSession(t *testing.T) {
+	old, _, adapter := newTestSession(t)
+	gb, _, _ := newTestSessionWith(t, adapter, testHostID)
+	defer gb.Close()
+
+	old.Close()
+	deadline := time.Now().Add(time.Second)
+	for old.State() != hid.SessionClosed && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s := adapter.Status().State; s != hid.SessionReady {
+		t.Errorf("expected the new session to stay ready, got %s", s)
+	}
+
+	// a connection attempt that fails while accepting must not show up either
+	pending := newSession(context.Background(), hid.Host{Address: "AA:BB:CC:DD:EE:02"}, adapter)
+	pending.Close()
+	if s := adapter.Status().State; s != hid.SessionReady {
+		t.Errorf("expected the new session to stay ready, got %s", s)
+	}
+	if pending.State() != hid.SessionClosed {
+		t.Errorf("expected the failed attempt to be closed, got %s", pending.State())
+	}
+}
+
+func TestSessionClosedOnCancelWhileAccepting(t *testing.T) {
+	adapter := hid.NewBluetoothKeyboardAdapter()
+	ctx, cancel := context.WithCancel(context.Background())
+	gb := newSession(ctx, hid.Host{Address: "AA:BB:CC:DD:EE:02"}, adapter)
+	if gb.State() != hid.SessionAccepting {
+		t.Fatalf("expected accepting session, got %s", gb.State())
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for gb.State() != hid.SessionClosed && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if gb.State() != hid.SessionClosed {
+		t.Errorf("expected closed session, got %s", gb.State())
+	}
+}
+
+func TestRequestDisconnectionOfUnknownDevice(t *testing.T) {
+	p := &HidProfile{gb: make(map[dbus.ObjectPath]*GoBt)}
+	if err := p.RequestDisconnection("/org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF"); err != nil {
+		t.Error(err)
+	}
+}
+
+func waitForState(t *testing.T, adapter *hid.BluetoothKeyboardAdapter, state hid.SessionState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for adapter.Status().State != state && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s := adapter.Status().State; s != state {
+		t.Fatalf("expected state %s, got %s", state, s)
+	}
+}
@@ -0,0 +1,113 @@
+// Package transport abstracts the channels HIDP messages travel on, so the
+// keyboard stack can run on L2CAP sockets as well as on local test doubles.
+package transport
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Transport carries whole HIDP messages. Every Write is delivered as one
+// message and every Read returns at most one message, just like the L2CAP
+// SEQPACKET sockets of the bluetooth package which implement this interface.
+type Transport interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+// pipeBuffer is the number of messages a pipe end holds before Write blocks
+const pipeBuffer = 64
+
+type pipe struct {
+	rx <-chan []byte
+	tx chan<- []byte
+
+	closed chan struct{}
+	once   *sync.Once
+}
+
+// Pipe creates a connected pair of in-memory transports. Messages written to
+// one end are read from the other. Closing either end closes both.
+func Pipe() (Transport, Transport) {
+	a := make(chan []byte, pipeBuffer)
+	b := make(chan []byte, pipeBuffer)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	return &pipe{rx: a, tx: b, closed: closed, once: once},
+		&pipe{rx: b, tx: a, closed: closed, once: once}
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	// deliver what has been sent before the pipe was closed
+	select {
+	case m := <-p.rx:
+		return copy(b, m), nil
+	default:
+	}
+
+	select {
+	case m := <-p.rx:
+		return copy(b, m), nil
+	case <-p.closed:
+		return 0, io.EOF
+	}
+}
+
+func (p *pipe) Write(b []byte) (int, error) {
+	m := make([]byte, len(b))
+	copy(m, b)
+
+	select {
+	case <-p.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	select {
+	case p.tx <- m:
+		return len(b), nil
+	case <-p.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (p *pipe) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+// SocketPair creates a connected pair of AF_UNIX SOCK_SEQPACKET sockets.
+// Like L2CAP they keep message boundaries, which makes them a faithful
+// stand-in for the HID channels on machines without Bluetooth.
+func SocketPair() (Transport, Transport, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a, err := fileConn(fds[0])
+	if err != nil {
+		unix.Close(fds[1])
+		return nil, nil, err
+	}
+	b, err := fileConn(fds[1])
+	if err != nil {
+		a.Close()
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+func fileConn(fd int) (net.Conn, error) {
+	f := os.NewFile(uintptr(fd), "seqpacket")
+	// FileConn works on a duplicate of the descriptor
+	defer f.Close()
+	return net.FileConn(f)
+}
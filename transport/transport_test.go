@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func testMessageBoundaries(t *testing.T, a, b Transport) {
+	messages := [][]byte{{0xa1, 0x13, 0x03}, {0xa1, 0x02, 0, 0, 4, 0, 0, 0, 0, 0}, {0x00}}
+	for _, m := range messages {
+		if _, err := a.Write(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 1024)
+	for _, m := range messages {
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], m) {
+			t.Errorf("expected message %x, got %x", m, buf[:n])
+		}
+	}
+
+	a.Close()
+	if n, err := b.Read(buf); err != io.EOF {
+		t.Errorf("expected EOF after close, got %d %v", n, err)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+	testMessageBoundaries(t, a, b)
+}
+
+func TestSocketPair(t *testing.T) {
+	a, b, err := SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	testMessageBoundaries(t, a, b)
+}